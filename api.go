@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/ubie-oss/flow/v4/flow"
+	"github.com/ubie-oss/flow/v4/history"
+)
+
+// RolloutRequest is the body of POST /api/v1/rollouts
+type RolloutRequest struct {
+	Image  string `json:"image"`
+	Tag    string `json:"tag"`
+	Digest string `json:"digest,omitempty"`
+	// Envs limits the rollout to the environments, all of them if empty
+	Envs      []string `json:"envs,omitempty"`
+	SourceSHA string   `json:"source_sha,omitempty"`
+	DryRun    bool     `json:"dry_run,omitempty"`
+}
+
+// RolloutResponse is the response of POST /api/v1/rollouts
+type RolloutResponse struct {
+	Status  int           `json:"status"`
+	Image   string        `json:"image,omitempty"`
+	Tag     string        `json:"tag,omitempty"`
+	Results []flow.Result `json:"results"`
+	Error   string        `json:"error,omitempty"`
+}
+
+func handleRollout(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req RolloutRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		slog.Error("Failed to decode rollout request", "error", err)
+		renderRollout(w, r, http.StatusBadRequest, &RolloutResponse{Error: err.Error()})
+		return
+	}
+	res := &RolloutResponse{
+		Image:   req.Image,
+		Tag:     req.Tag,
+		Results: flow.Results{},
+	}
+	if req.Image == "" || req.Tag == "" {
+		res.Error = "image and tag are required"
+		renderRollout(w, r, http.StatusBadRequest, res)
+		return
+	}
+
+	opts := flow.Options{
+		DryRun:     req.DryRun || r.URL.Query().Get("dry_run") == "true",
+		Envs:       req.Envs,
+		SourceSHA:  req.SourceSHA,
+		ReceivedAt: time.Now(),
+	}
+	flow.CountEvent("api", []flow.Rollout{{Image: req.Image, Version: req.Tag, Digest: req.Digest, Options: opts}})
+	results, err := f.ProcessImage(ctx, req.Image, req.Tag, req.Digest, opts)
+	if err != nil {
+		slog.Error("Failed to process rollout request", "image", req.Image, "tag", req.Tag, "error", err)
+		res.Error = err.Error()
+		status := errorStatus(err)
+		if errors.Is(err, flow.ErrApplicationNotFound) {
+			status = http.StatusNotFound
+		}
+		renderRollout(w, r, status, res)
+		return
+	}
+	if results != nil {
+		res.Results = results
+	}
+
+	status := http.StatusOK
+	if err := results.Err(); err != nil {
+		slog.Error("Failed to roll out", "image", req.Image, "tag", req.Tag, "error", err)
+		status = errorStatus(err)
+	}
+	renderRollout(w, r, status, res)
+}
+
+// errorStatus returns 503 for transient errors so that clients know to try again, and 500 otherwise.
+func errorStatus(err error) int {
+	if flow.IsTransient(err) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func renderRollout(w http.ResponseWriter, r *http.Request, status int, res *RolloutResponse) {
+	res.Status = status
+	render.Status(r, status)
+	render.JSON(w, r, res)
+}
+
+// RolloutHistoryResponse is the response of GET /api/v1/rollouts and GET /api/v1/rollouts/{id}.
+// Rollouts is set by the former and Rollout by the latter.
+type RolloutHistoryResponse struct {
+	Status   int               `json:"status"`
+	Rollouts *[]history.Record `json:"rollouts,omitempty"`
+	Rollout  *history.Record   `json:"rollout,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// handleListRollouts lists the recorded rollouts, the most recent first,
+// filtered by the app, image, env and status query parameters and limited to limit of them, 100 by default.
+func handleListRollouts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := history.Filter{
+		App:    query.Get("app"),
+		Image:  query.Get("image"),
+		Env:    query.Get("env"),
+		Status: history.Status(query.Get("status")),
+		Limit:  100,
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			renderHistory(w, r, http.StatusBadRequest, &RolloutHistoryResponse{Error: "limit must be a positive integer"})
+			return
+		}
+		filter.Limit = n
+	}
+
+	rollouts, err := f.History().List(r.Context(), filter)
+	if err != nil {
+		slog.Error("Failed to list rollouts", "error", err)
+		renderHistory(w, r, http.StatusInternalServerError, &RolloutHistoryResponse{Error: err.Error()})
+		return
+	}
+	renderHistory(w, r, http.StatusOK, &RolloutHistoryResponse{Rollouts: &rollouts})
+}
+
+func handleGetRollout(w http.ResponseWriter, r *http.Request) {
+	rollout, err := f.History().Get(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, history.ErrNotFound) {
+		renderHistory(w, r, http.StatusNotFound, &RolloutHistoryResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to get rollout", "error", err)
+		renderHistory(w, r, http.StatusInternalServerError, &RolloutHistoryResponse{Error: err.Error()})
+		return
+	}
+	renderHistory(w, r, http.StatusOK, &RolloutHistoryResponse{Rollout: rollout})
+}
+
+func renderHistory(w http.ResponseWriter, r *http.Request, status int, res *RolloutHistoryResponse) {
+	res.Status = status
+	render.Status(r, status)
+	render.JSON(w, r, res)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ubie-oss/flow/v4/flow"
+	"golang.org/x/oauth2"
+)
+
+func TestHandleRollout(t *testing.T) {
+	// GitHub fails every request with the status of the test case
+	var githubStatus int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(githubStatus)
+		_, _ = w.Write([]byte(`{"message": "error"}`))
+	}))
+	defer server.Close()
+	client := setUpFlow(t, testConfig, server)
+
+	tests := []struct {
+		name         string
+		body         string
+		githubStatus int
+		want         int
+	}{
+		{"malformed request", `{"image":`, http.StatusOK, http.StatusBadRequest},
+		{"missing tag", `{"image": "gcr.io/example/foo"}`, http.StatusOK, http.StatusBadRequest},
+		{"unknown image", `{"image": "gcr.io/example/bar", "tag": "v1"}`, http.StatusOK, http.StatusNotFound},
+		{"permanent failure", `{"image": "gcr.io/example/foo", "tag": "v1"}`, http.StatusNotFound, http.StatusInternalServerError},
+		{"transient failure", `{"image": "gcr.io/example/foo", "tag": "v2"}`, http.StatusBadGateway, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			githubStatus = tt.githubStatus
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/rollouts", strings.NewReader(tt.body))
+			req = req.WithContext(context.WithValue(req.Context(), oauth2.HTTPClient, client))
+			w := httptest.NewRecorder()
+			handleRollout(w, req)
+
+			assert.Equal(t, tt.want, w.Code)
+			var res RolloutResponse
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+			assert.Equal(t, tt.want, res.Status)
+			assert.NotEmpty(t, res.Error+resultErrors(res.Results))
+		})
+	}
+}
+
+func resultErrors(results flow.Results) string {
+	var errs []string
+	for _, r := range results {
+		errs = append(errs, r.Error)
+	}
+	return strings.Join(errs, "\n")
+}
+
+func TestNewResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		results flow.Results
+		err     error
+		want    int
+	}{
+		{"succeeded", flow.Results{{Env: "production", PullRequestURL: "https://github.com/ubie-oss/manifests/pull/1"}}, nil, http.StatusOK},
+		// redelivering the event cannot fix permanent failures
+		{"permanent failure", nil, flow.ErrApplicationNotFound, http.StatusOK},
+		{"failed manifest", flow.Results{{Env: "production", Error: "not found"}}, nil, http.StatusOK},
+		{"transient failure", nil, context.DeadlineExceeded, http.StatusServiceUnavailable},
+		{"transient and permanent failures", flow.Results{{Env: "production", Error: "not found"}}, errors.Join(errors.New("bad"), context.DeadlineExceeded), http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := newResponse(tt.results, tt.err)
+			assert.Equal(t, tt.want, res.Status)
+			assert.Equal(t, tt.err != nil || tt.results.Err() != nil, res.Error != "")
+
+			w := httptest.NewRecorder()
+			renderResponse(w, httptest.NewRequest(http.MethodPost, "/", nil), res)
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
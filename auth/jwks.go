@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// GoogleJWKSURL is where Google publishes the keys signing the OIDC tokens of Pub/Sub push.
+const GoogleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+const fetchTimeout = 10 * time.Second
+
+// KeySource provides the public keys verifying tokens by key ID.
+type KeySource interface {
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// StaticKeySource is a KeySource with fixed keys, mainly for tests and local runs.
+type StaticKeySource map[string]crypto.PublicKey
+
+func (s StaticKeySource) Key(_ context.Context, kid string) (crypto.PublicKey, error) {
+	key, ok := s[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+// jwksKeySource fetches a JSON Web Key Set and caches it.
+type jwksKeySource struct {
+	url    string
+	client *http.Client
+	ttl    time.Duration
+
+	// group lets concurrent requests wait for a single fetch without holding mu
+	group singleflight.Group
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// NewJWKSKeySource returns a KeySource fetching the keys from url and caching them for ttl.
+// Keys are fetched again before ttl passes if a token has an unknown key ID, at most once a minute.
+func NewJWKSKeySource(url string, client *http.Client, ttl time.Duration) KeySource {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &jwksKeySource{
+		url:    url,
+		client: client,
+		ttl:    ttl,
+	}
+}
+
+func (s *jwksKeySource) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	key, ok := s.keys[kid]
+	expired := time.Since(s.fetchedAt) > s.ttl
+	// refresh on an unknown key as keys are rotated, but do not let tokens with random key IDs hammer the endpoint
+	refresh := expired || (!ok && time.Since(s.fetchedAt) > time.Minute)
+	s.mu.Unlock()
+
+	if refresh {
+		v, err, _ := s.group.Do("", func() (interface{}, error) {
+			// the requests waiting for the fetch must not fail with the cancellation of the one running it
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), fetchTimeout)
+			defer cancel()
+			keys, err := s.fetch(ctx)
+			if err != nil {
+				return nil, err
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.keys = keys
+			s.fetchedAt = time.Now()
+			return keys, nil
+		})
+		if err != nil {
+			if ok {
+				return key, nil
+			}
+			return nil, err
+		}
+		key, ok = v.(map[string]crypto.PublicKey)[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+type jwks struct {
+	Keys []struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+func (s *jwksKeySource) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch keys: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch keys: %s", res.Status)
+	}
+
+	var set jwks
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode keys: %w", err)
+	}
+	return parseJWKS(set)
+}
+
+func parseJWKS(set jwks) (map[string]crypto.PublicKey, error) {
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of key %q: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent of key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
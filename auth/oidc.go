@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var googleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+// OIDCVerifier verifies the OIDC tokens Pub/Sub push attaches as "Authorization: Bearer".
+type OIDCVerifier struct {
+	// Audience is the audience configured in the push subscription, the push endpoint URL by default.
+	Audience string
+	// Issuers are the accepted issuers, the ones of Google if empty.
+	Issuers []string
+	// ServiceAccounts are the accepted emails of the service accounts of the push subscriptions.
+	// Any verified email is accepted if empty.
+	ServiceAccounts []string
+	Keys            KeySource
+}
+
+type oidcClaims struct {
+	jwt.RegisteredClaims
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// Verify verifies the token and returns the email of the service account which signed it.
+func (v *OIDCVerifier) Verify(ctx context.Context, token string) (string, error) {
+	claims := &oidcClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256"}))
+	_, err := parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.Keys.Key(ctx, kid)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if !claims.VerifyAudience(v.Audience, true) {
+		return "", fmt.Errorf("invalid audience %v", claims.Audience)
+	}
+	issuers := v.Issuers
+	if len(issuers) == 0 {
+		issuers = googleIssuers
+	}
+	if !slices.Contains(issuers, claims.Issuer) {
+		return "", fmt.Errorf("invalid issuer %q", claims.Issuer)
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		return "", errors.New("email is missing or not verified")
+	}
+	if len(v.ServiceAccounts) > 0 && !slices.Contains(v.ServiceAccounts, claims.Email) {
+		return "", fmt.Errorf("service account %s is not allowed", claims.Email)
+	}
+	return claims.Email, nil
+}
+
+// Middleware rejects requests without a valid token with 401.
+func (v *OIDCVerifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			slog.Warn("Missing bearer token", "path", r.URL.Path)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		email, err := v.Verify(r.Context(), token)
+		if err != nil {
+			slog.Warn("Invalid bearer token", "path", r.URL.Path, "error", err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		slog.Debug("Verified bearer token", "email", email)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
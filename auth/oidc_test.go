@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAudience = "https://flow.example.com/"
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	s, err := token.SignedString(key)
+	assert.Nil(t, err)
+	return s
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            "https://accounts.google.com",
+		"aud":            testAudience,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"email":          "pubsub@project.iam.gserviceaccount.com",
+		"email_verified": true,
+	}
+}
+
+func TestOIDCVerifier(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	v := &OIDCVerifier{
+		Audience:        testAudience,
+		ServiceAccounts: []string{"pubsub@project.iam.gserviceaccount.com"},
+		Keys:            StaticKeySource{"key1": &key.PublicKey},
+	}
+
+	testcases := []struct {
+		name   string
+		token  func() string
+		header string
+		status int
+	}{
+		{name: "valid", token: func() string { return signToken(t, key, "key1", validClaims()) }, status: http.StatusOK},
+		{name: "missing", token: func() string { return "" }, status: http.StatusUnauthorized},
+		{name: "wrong key", token: func() string { return signToken(t, otherKey, "key1", validClaims()) }, status: http.StatusUnauthorized},
+		{name: "unknown key id", token: func() string { return signToken(t, key, "key2", validClaims()) }, status: http.StatusUnauthorized},
+		{name: "expired", token: func() string {
+			c := validClaims()
+			c["exp"] = time.Now().Add(-time.Minute).Unix()
+			return signToken(t, key, "key1", c)
+		}, status: http.StatusUnauthorized},
+		{name: "wrong audience", token: func() string {
+			c := validClaims()
+			c["aud"] = "https://other.example.com/"
+			return signToken(t, key, "key1", c)
+		}, status: http.StatusUnauthorized},
+		{name: "wrong issuer", token: func() string {
+			c := validClaims()
+			c["iss"] = "https://evil.example.com"
+			return signToken(t, key, "key1", c)
+		}, status: http.StatusUnauthorized},
+		{name: "service account not allowed", token: func() string {
+			c := validClaims()
+			c["email"] = "someone@project.iam.gserviceaccount.com"
+			return signToken(t, key, "key1", c)
+		}, status: http.StatusUnauthorized},
+		{name: "email not verified", token: func() string {
+			c := validClaims()
+			c["email_verified"] = false
+			return signToken(t, key, "key1", c)
+		}, status: http.StatusUnauthorized},
+		{name: "unsigned", token: func() string {
+			s, _ := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+			return s
+		}, status: http.StatusUnauthorized},
+	}
+
+	handler := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			if token := tc.token(); token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tc.status, rec.Code)
+		})
+	}
+}
+
+func TestJWKSKeySource(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	var fetched int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "key1",
+				"kty": "RSA",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			}},
+		})
+	}))
+	defer server.Close()
+
+	v := &OIDCVerifier{
+		Audience: testAudience,
+		Keys:     NewJWKSKeySource(server.URL, server.Client(), time.Hour),
+	}
+	email, err := v.Verify(context.Background(), signToken(t, key, "key1", validClaims()))
+	assert.Nil(t, err)
+	assert.Equal(t, "pubsub@project.iam.gserviceaccount.com", email)
+
+	// cached
+	_, err = v.Verify(context.Background(), signToken(t, key, "key1", validClaims()))
+	assert.Nil(t, err)
+	// unknown keys do not refetch right after fetching
+	_, err = v.Verify(context.Background(), signToken(t, key, "key2", validClaims()))
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetched))
+
+	// concurrent requests share a single fetch
+	atomic.StoreInt32(&fetched, 0)
+	keys := NewJWKSKeySource(server.URL, server.Client(), time.Hour)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := keys.Key(context.Background(), "key1")
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetched))
+}
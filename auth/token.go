@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+)
+
+// StaticTokens accepts requests with one of the tokens as "Authorization: Bearer".
+type StaticTokens []string
+
+// Middleware rejects requests without a valid token with 401.
+func (t StaticTokens) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok || !t.valid(token) {
+			slog.Warn("Invalid bearer token", "path", r.URL.Path)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (t StaticTokens) valid(token string) bool {
+	valid := false
+	for _, expected := range t {
+		// compare all tokens in constant time
+		if expected != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
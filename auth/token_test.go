@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticTokens(t *testing.T) {
+	handler := StaticTokens{"", "secret1", "secret2"}.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	testcases := []struct {
+		header string
+		status int
+	}{
+		{header: "Bearer secret1", status: http.StatusOK},
+		{header: "bearer secret2", status: http.StatusOK},
+		{header: "Bearer secret3", status: http.StatusUnauthorized},
+		{header: "Bearer ", status: http.StatusUnauthorized},
+		{header: "Basic secret1", status: http.StatusUnauthorized},
+		{header: "", status: http.StatusUnauthorized},
+	}
+	for _, tc := range testcases {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("Authorization", tc.header)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, tc.status, rec.Code, tc.header)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ubie-oss/flow/v4/flow"
+)
+
+func usage() {
+	fmt.Fprint(os.Stderr, `Usage: flow <command> [flags]
+
+Commands:
+  serve     start the server receiving Pub/Sub push messages (default)
+  rollout   roll out a tag of an image, e.g. rollout --image gcr.io/foo/bar --tag v1.2.3
+  plan      print the changes a rollout would make without pushing them
+  validate  check the config file
+
+Run "flow <command> -h" for the flags of each command.
+`)
+}
+
+// rolloutFlags are the flags shared by rollout and plan.
+type rolloutFlags struct {
+	configPath string
+	image      string
+	tag        string
+	digest     string
+}
+
+func parseRolloutFlags(name string, args []string) (*rolloutFlags, *flag.FlagSet) {
+	rf := &rolloutFlags{}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&rf.configPath, "config", os.Getenv("FLOW_CONFIG_PATH"), "path to the config file")
+	fs.StringVar(&rf.image, "image", "", "image to roll out, e.g. gcr.io/foo/bar")
+	fs.StringVar(&rf.tag, "tag", "", "tag of the image to roll out")
+	fs.StringVar(&rf.digest, "digest", "", "digest of the image, e.g. sha256:...")
+	return rf, fs
+}
+
+func (rf *rolloutFlags) process(opts flow.Options) (flow.Results, error) {
+	if rf.image == "" || rf.tag == "" {
+		return nil, errors.New("--image and --tag are required")
+	}
+	cfg, err := getConfig(rf.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the file: %w", err)
+	}
+	fl, err := initFlow(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the config: %w", err)
+	}
+	return fl.ProcessImage(context.Background(), rf.image, rf.tag, rf.digest, opts)
+}
+
+func runRollout(args []string) error {
+	rf, fs := parseRolloutFlags("rollout", args)
+	dryRun := fs.Bool("dry-run", false, "print the changes instead of pushing them")
+	_ = fs.Parse(args)
+
+	results, err := rf.process(flow.Options{DryRun: *dryRun})
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		printPlans(os.Stdout, results.Plans())
+		printFailures(os.Stdout, results)
+	} else {
+		printResults(os.Stdout, results)
+	}
+	return results.Err()
+}
+
+func runPlan(args []string) error {
+	rf, fs := parseRolloutFlags("plan", args)
+	_ = fs.Parse(args)
+
+	results, err := rf.process(flow.Options{DryRun: true})
+	if err != nil {
+		return err
+	}
+	plans := results.Plans()
+	if len(plans) == 0 && results.Err() == nil {
+		fmt.Println("No manifests to change.")
+		return nil
+	}
+	printPlans(os.Stdout, plans)
+	printFailures(os.Stdout, results)
+	return results.Err()
+}
+
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("FLOW_CONFIG_PATH"), "path to the config file")
+	_ = fs.Parse(args)
+
+	cfg, err := getConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("could not read the file: %w", err)
+	}
+	if _, err := initFlow(cfg); err != nil {
+		return fmt.Errorf("error parsing the config: %w", err)
+	}
+	fmt.Printf("%s is valid.\n", *configPath)
+	return nil
+}
+
+func printResults(w io.Writer, results flow.Results) {
+	if len(results) == 0 {
+		fmt.Fprintln(w, "No manifests to change.")
+	}
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			fmt.Fprintf(w, "%s: failed: %s\n", r.Env, r.Error)
+		case r.Skipped != "":
+			fmt.Fprintf(w, "%s: skipped: %s\n", r.Env, r.Skipped)
+		case r.PullRequestURL != "":
+			fmt.Fprintf(w, "%s: %s\n", r.Env, r.PullRequestURL)
+		default:
+			fmt.Fprintf(w, "%s: committed %s to %s/%s %s\n", r.Env, r.CommitSHA, r.Owner, r.Repo, r.Branch)
+		}
+	}
+}
+
+// printFailures prints the manifests which could not be planned.
+func printFailures(w io.Writer, results flow.Results) {
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Fprintf(w, "%s: failed: %s\n", r.Env, r.Error)
+		}
+	}
+}
+
+func printPlans(w io.Writer, plans []flow.Plan) {
+	for _, plan := range plans {
+		fmt.Fprintf(w, "# %s: %s/%s (%s <- %s)\n", plan.Env, plan.Owner, plan.Repo, plan.BaseBranch, plan.Branch)
+		fmt.Fprintf(w, "Title: %s\n", plan.Title)
+		if len(plan.Labels) > 0 {
+			fmt.Fprintf(w, "Labels: %s\n", strings.Join(plan.Labels, ", "))
+		}
+		if plan.Body != "" {
+			fmt.Fprintf(w, "\n%s\n", strings.TrimRight(plan.Body, "\n"))
+		}
+		fmt.Fprintln(w)
+
+		paths := make([]string, 0, len(plan.Diffs))
+		for path := range plan.Diffs {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			fmt.Fprint(w, plan.Diffs[path])
+		}
+		if len(paths) == 0 {
+			fmt.Fprintln(w, "No changes in the files.")
+		}
+		fmt.Fprintln(w)
+	}
+}
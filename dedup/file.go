@@ -0,0 +1,114 @@
+package dedup
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileStore is a MemoryStore persisted to a file, so that the keys survive restarts.
+// The file has a key per line and is appended to on Add, even for keys already recorded so that
+// the last line of a key tells when it was used; it is rewritten on Remove and when it grows to twice the size. The file must not be shared by processes.
+type FileStore struct {
+	*MemoryStore
+	path  string
+	lines int
+}
+
+var _ Store = &FileStore{}
+
+// NewFileStore returns a Store keeping up to size keys in the file at path, loading the ones already there.
+func NewFileStore(path string, size int) (*FileStore, error) {
+	s := &FileStore{
+		MemoryStore: NewMemoryStore(size),
+		path:        path,
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if key := scanner.Text(); key != "" {
+			s.MemoryStore.add(key)
+			s.lines++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *FileStore) Add(_ context.Context, key string) (bool, error) {
+	if strings.ContainsAny(key, "\r\n") {
+		return false, fmt.Errorf("invalid key %q", key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	added := s.MemoryStore.add(key)
+	if s.lines+1 >= 2*s.size {
+		return added, s.rewrite()
+	}
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return added, err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(key + "\n"); err != nil {
+		return added, err
+	}
+	s.lines++
+	return added, nil
+}
+
+func (s *FileStore) Remove(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.elements[key]; !ok {
+		return nil
+	}
+	s.MemoryStore.remove(key)
+	return s.rewrite()
+}
+
+// rewrite replaces the file with the keys in memory, through a temporary file so that it is never half written.
+func (s *FileStore) rewrite() error {
+	keys := s.MemoryStore.keys()
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, key := range keys {
+		_, _ = w.WriteString(key + "\n")
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	s.lines = len(keys)
+	return nil
+}
@@ -0,0 +1,84 @@
+// Package dedup remembers the events and rollouts already processed, as Pub/Sub delivers messages
+// at least once and registries sometimes notify the same push twice.
+package dedup
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// Store records keys of processed events.
+type Store interface {
+	// Add records the key and reports whether it is new, false if it was already recorded.
+	Add(ctx context.Context, key string) (bool, error)
+	// Remove forgets the key so that the event can be processed again, e.g. after it failed.
+	Remove(ctx context.Context, key string) error
+}
+
+// MemoryStore keeps the most recently used keys in memory, in a least recently used cache.
+type MemoryStore struct {
+	size int
+
+	mu       sync.Mutex
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+var _ Store = &MemoryStore{}
+
+// NewMemoryStore returns a Store keeping up to size keys, evicting the least recently used ones.
+// Adding a key already recorded uses it, so that events redelivered for long are not forgotten.
+func NewMemoryStore(size int) *MemoryStore {
+	if size <= 0 {
+		size = 1
+	}
+	return &MemoryStore{
+		size:     size,
+		order:    list.New(),
+		elements: map[string]*list.Element{},
+	}
+}
+
+func (s *MemoryStore) Add(_ context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.add(key), nil
+}
+
+func (s *MemoryStore) Remove(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+	return nil
+}
+
+func (s *MemoryStore) add(key string) bool {
+	if e, ok := s.elements[key]; ok {
+		s.order.MoveToBack(e)
+		return false
+	}
+	s.elements[key] = s.order.PushBack(key)
+	for s.order.Len() > s.size {
+		oldest := s.order.Front()
+		s.order.Remove(oldest)
+		delete(s.elements, oldest.Value.(string))
+	}
+	return true
+}
+
+func (s *MemoryStore) remove(key string) {
+	if e, ok := s.elements[key]; ok {
+		s.order.Remove(e)
+		delete(s.elements, key)
+	}
+}
+
+// keys returns the keys from the least recently used one.
+func (s *MemoryStore) keys() []string {
+	keys := make([]string, 0, s.order.Len())
+	for e := s.order.Front(); e != nil; e = e.Next() {
+		keys = append(keys, e.Value.(string))
+	}
+	return keys
+}
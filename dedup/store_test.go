@@ -0,0 +1,85 @@
+package dedup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(2)
+
+	added, err := s.Add(ctx, "a")
+	assert.Nil(t, err)
+	assert.True(t, added)
+	added, _ = s.Add(ctx, "a")
+	assert.False(t, added)
+
+	// b and c evict a
+	_, _ = s.Add(ctx, "b")
+	_, _ = s.Add(ctx, "c")
+	assert.Equal(t, []string{"b", "c"}, s.keys())
+	added, _ = s.Add(ctx, "a")
+	assert.True(t, added)
+	assert.Equal(t, []string{"c", "a"}, s.keys())
+
+	// c is used again, so a is the least recently used one
+	added, _ = s.Add(ctx, "c")
+	assert.False(t, added)
+	assert.Equal(t, []string{"a", "c"}, s.keys())
+	_, _ = s.Add(ctx, "b")
+	assert.Equal(t, []string{"c", "b"}, s.keys())
+
+	assert.Nil(t, s.Remove(ctx, "c"))
+	assert.Nil(t, s.Remove(ctx, "unknown"))
+	assert.Equal(t, []string{"b"}, s.keys())
+	added, _ = s.Add(ctx, "c")
+	assert.True(t, added)
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "dedup")
+
+	s, err := NewFileStore(path, 3)
+	assert.Nil(t, err)
+	for _, key := range []string{"a", "b", "c"} {
+		added, err := s.Add(ctx, key)
+		assert.Nil(t, err)
+		assert.True(t, added)
+	}
+	assert.Nil(t, s.Remove(ctx, "b"))
+
+	// reloaded after a restart
+	s, err = NewFileStore(path, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "c"}, s.keys())
+	added, _ := s.Add(ctx, "a")
+	assert.False(t, added)
+	added, _ = s.Add(ctx, "b")
+	assert.True(t, added)
+
+	// a was used after c, which is evicted first after a restart
+	s, err = NewFileStore(path, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c", "a", "b"}, s.keys())
+
+	// compacted when the file grows to twice the size
+	for _, key := range []string{"d", "e", "f"} {
+		_, err := s.Add(ctx, key)
+		assert.Nil(t, err)
+	}
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "b\nd\ne\nf\n", string(content))
+	s, err = NewFileStore(path, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"d", "e", "f"}, s.keys())
+
+	_, err = s.Add(ctx, "g\nh")
+	assert.NotNil(t, err)
+}
@@ -1,5 +1,7 @@
 package flow
 
+import "gopkg.in/yaml.v3"
+
 type Config struct {
 	ApplicationList []Application `yaml:"applications"`
 	GitAuthor       GitAuthor     `yaml:"git_author"`
@@ -22,8 +24,16 @@ type Application struct {
 	AdditionalRewriteKeys   []string `yaml:"additional_rewrite_keys"`
 	AdditionalRewritePrefix []string `yaml:"additional_rewrite_prefix"`
 
+	// PinDigest writes image:tag@sha256:... instead of image:tag when the digest of the pushed image is known.
+	// With DigestOnly, image@sha256:... is written without the tag.
+	PinDigest  bool `yaml:"pin_digest"`
+	DigestOnly bool `yaml:"digest_only"`
+
 	Image     string     `yaml:"image"`
 	Manifests []Manifest `yaml:"manifests"`
+
+	// Notifications are notified of the rollouts to all the manifests.
+	Notifications []Notification `yaml:"notifications"`
 }
 
 type Manifest struct {
@@ -34,17 +44,108 @@ type Manifest struct {
 	HideSourceReleasePullRequests bool     `yaml:"hide_source_release_pull_requests"`
 	ManifestOwner                 string   `yaml:"manifest_owner"`
 	ManifestName                  string   `yaml:"manifest_name"`
-	Files                         []string `yaml:"files"`
+	Files                         []File   `yaml:"files"`
 	Filters                       Filters  `yaml:"filters"`
 	PRBody                        string   `yaml:"pr_body"`
 	BaseBranch                    string   `yaml:"base_branch"`
 	CommitWithoutPR               bool     `yaml:"commit_without_pr"`
 	Labels                        []string `yaml:"labels"`
+
+	// BranchName and CommitMessage override the generated branch name and commit message.
+	// Like PRBody, they may reference ${version}, ${env} and named groups captured by Filters.IncludePatterns.
+	BranchName    string `yaml:"branch_name"`
+	CommitMessage string `yaml:"commit_message"`
+
+	// ForbidDowngrade skips rollouts of a version older than the one in the files.
+	// If DowngradeLabel is set, the PR is opened with the label and a warning instead.
+	ForbidDowngrade bool   `yaml:"forbid_downgrade"`
+	DowngradeLabel  string `yaml:"downgrade_label"`
+	// VersionOrdering is either "semver" (default) or "timestamp" to compare the first 8-14 digits in tags.
+	VersionOrdering string `yaml:"version_ordering"`
+
+	// SupersedePRs closes the open PRs flow created for older versions of the application to this manifest,
+	// with a comment linking the new PR, and deletes their branches. It cannot be used with the single PR strategy,
+	// which has no other PR to close.
+	SupersedePRs bool `yaml:"supersede_prs"`
+	// PRStrategy is either "new" (default) to open a PR for each version, or "single" to keep a PR
+	// from a long-lived branch, rollout/<env>-<app> by default, which is reset and updated with each version.
+	PRStrategy string `yaml:"pr_strategy"`
+
+	// Notifications are notified of the rollouts to the manifest, in addition to the ones of the application.
+	Notifications []Notification `yaml:"notifications"`
+}
+
+const (
+	PRStrategyNew    = "new"
+	PRStrategySingle = "single"
+)
+
+// Notification is a target notified of rollouts.
+type Notification struct {
+	// Type is "slack" to post the message to an incoming webhook, or "webhook" to post the event as JSON.
+	Type string `yaml:"type"`
+	// URL, or URLEnv naming the environment variable of it to keep secret URLs such as Slack's out of the config.
+	URL    string `yaml:"url"`
+	URLEnv string `yaml:"url_env"`
+	// SecretEnv names the environment variable of the key signing the payloads of webhooks with HMAC-SHA256.
+	SecretEnv string `yaml:"secret_env"`
+	// Events are the events notified, all of them if empty: pull_request_opened, auto_merged, merged,
+	// closed, checks_failed and failed.
+	Events []string `yaml:"events"`
+	// Template is a Go template of the message, which may reference the fields of the event such as
+	// {{.Image}}, {{.Tag}}, {{.Env}}, {{.PullRequestURL}} and {{.Error}}.
+	Template string `yaml:"template"`
+}
+
+const (
+	FileModeRegex     = "regex"
+	FileModeYAML      = "yaml"
+	FileModeKustomize = "kustomize"
+	FileModeHelm      = "helm"
+)
+
+// File is a file to rewrite in the manifest repository.
+// It can be written as a plain path, which is rewritten in the regex mode.
+type File struct {
+	Path string `yaml:"path"`
+	// Mode is "regex" (default) to rewrite lines matching the image and version keys,
+	// "yaml" to rewrite the values at YAMLPaths keeping the rest of the file as is,
+	// "kustomize" to set newTag of the image in the images list of a kustomization.yaml,
+	// or "helm" to set tag next to the repository of the image in a Helm values.yaml.
+	Mode string `yaml:"mode"`
+	// YAMLPaths are yq-style paths such as spec.template.spec.containers[name=app].image.
+	// A value that is a reference of the image gets the new tag and a plain tag becomes the version.
+	// References of other images are left as they are.
+	YAMLPaths []string `yaml:"yaml_paths"`
+}
+
+func (f *File) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		f.Path = value.Value
+		return nil
+	}
+	type plain File
+	return value.Decode((*plain)(f))
 }
 
 type Filters struct {
 	IncludePrefixes []string `yaml:"include_prefixes"`
 	ExcludePrefixes []string `yaml:"exclude_prefixes"`
+
+	// IncludePatterns and ExcludePatterns are regular expressions matched against the tag.
+	// Named groups of the matching include pattern, e.g. "^qa-(?<ticket>[A-Z]+-\d+)-",
+	// can be referenced as ${ticket} in PRBody, BranchName and CommitMessage.
+	IncludePatterns []string `yaml:"include_patterns"`
+	ExcludePatterns []string `yaml:"exclude_patterns"`
+	// IncludeGlobs and ExcludeGlobs are shell patterns such as "release-*" matched against the tag.
+	IncludeGlobs []string `yaml:"include_globs"`
+	ExcludeGlobs []string `yaml:"exclude_globs"`
+
+	// Semver is a semantic version constraint such as ">=2.4.0 <3" or "~1.2 || ^2.0".
+	// Tags that are not semantic versions never match when it is set.
+	Semver string `yaml:"semver"`
+	// IncludePrerelease lets pre-release versions (e.g. v2.5.0-rc.1) satisfy Semver.
+	IncludePrerelease bool `yaml:"include_prerelease"`
 }
 
 type GitAuthor struct {
@@ -0,0 +1,25 @@
+package flow
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestFileUnmarshalYAML(t *testing.T) {
+	var m Manifest
+	err := yaml.Unmarshal([]byte(`
+files:
+  - overlays/dev/deployment.yaml
+  - path: overlays/dev/cronjob.yaml
+    mode: yaml
+    yaml_paths:
+      - spec.jobTemplate.spec.template.spec.containers[name=app].image
+`), &m)
+	assert.Nil(t, err)
+	assert.Equal(t, []File{
+		{Path: "overlays/dev/deployment.yaml"},
+		{Path: "overlays/dev/cronjob.yaml", Mode: FileModeYAML, YAMLPaths: []string{"spec.jobTemplate.spec.template.spec.containers[name=app].image"}},
+	}, m.Files)
+}
@@ -0,0 +1,63 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/google/go-github/v75/github"
+	"github.com/ubie-oss/flow/v4/gitbot"
+)
+
+const defaultDedupSize = 10000
+
+// messageKey identifies a delivery of an event about the image.
+// Events about multiple images, such as releases, are delivered once for each image.
+func messageKey(messageID, image string) string {
+	return fmt.Sprintf("message:%s:%s", messageID, image)
+}
+
+// rolloutKey identifies the rollout of the version of the image to the manifest repository.
+func rolloutKey(image, version string, manifest Manifest, repo *gitbot.Repo) string {
+	return fmt.Sprintf("rollout:%s/%s:%s:%s:%s:%s", repo.SourceOwner, repo.SourceRepo, repo.BaseBranch, manifest.Env, image, version)
+}
+
+// markSeen records the key and reports whether it was seen before.
+// Errors of the store are logged and the key is treated as new, as processing twice is better than never.
+func (f *Flow) markSeen(ctx context.Context, key string) bool {
+	if f.dedup == nil {
+		return false
+	}
+	added, err := f.dedup.Add(ctx, key)
+	if err != nil {
+		slog.Warn("Failed to record processed key", "key", key, "error", err)
+		return false
+	}
+	return !added
+}
+
+// forget removes the key so that the event is processed again when it is delivered again.
+func (f *Flow) forget(ctx context.Context, key string) {
+	if f.dedup == nil {
+		return
+	}
+	if err := f.dedup.Remove(ctx, key); err != nil {
+		slog.Warn("Failed to remove processed key", "key", key, "error", err)
+	}
+}
+
+// findOpenPullRequest returns the open PR of the same rollout, pushed in any of the attempts, or nil.
+// Each attempt pushes its own branch, so the branches are looked up one by one instead of listing every open PR.
+func findOpenPullRequest(ctx context.Context, client *github.Client, repo *gitbot.Repo, branchName string, attempts int) (*github.PullRequest, error) {
+	for attempt := 1; attempt <= attempts; attempt++ {
+		branch := fmt.Sprintf("%s-%d", branchName, attempt)
+		pr, err := gitbot.FindOpenPullRequest(ctx, client, repo.SourceOwner, repo.SourceRepo, repo.BaseBranch, branch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find pull request: %w", err)
+		}
+		if pr != nil {
+			return pr, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,27 @@
+package flow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ubie-oss/flow/v4/dedup"
+)
+
+func TestMarkSeen(t *testing.T) {
+	ctx := context.Background()
+	f := &Flow{dedup: dedup.NewMemoryStore(10)}
+
+	key := messageKey("123", "gcr.io/foo/app")
+	assert.False(t, f.markSeen(ctx, key))
+	assert.True(t, f.markSeen(ctx, key))
+	assert.False(t, f.markSeen(ctx, messageKey("123", "gcr.io/foo/other")))
+
+	f.forget(ctx, key)
+	assert.False(t, f.markSeen(ctx, key))
+
+	// without a store nothing is deduplicated
+	f = &Flow{}
+	assert.False(t, f.markSeen(ctx, key))
+	assert.False(t, f.markSeen(ctx, key))
+}
@@ -0,0 +1,60 @@
+package flow
+
+import (
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/dlclark/regexp2"
+)
+
+const (
+	VersionOrderingSemver    = "semver"
+	VersionOrderingTimestamp = "timestamp"
+)
+
+// timestampRegex finds a timestamp such as 20261018 or 20261018123456 embedded in a tag.
+var timestampRegex = regexp2.MustCompile(`\d{8,14}`, 0)
+
+// findNewerVersion returns the first of oldVersions that is newer than version under the ordering.
+// Versions that cannot be ordered are never considered newer.
+func findNewerVersion(ordering string, oldVersions []string, version string) (string, bool) {
+	for _, oldVersion := range oldVersions {
+		if compareVersions(ordering, oldVersion, version) > 0 {
+			return oldVersion, true
+		}
+	}
+	return "", false
+}
+
+// compareVersions returns 1 if a is newer than b, -1 if a is older than b and 0 otherwise.
+func compareVersions(ordering, a, b string) int {
+	switch ordering {
+	case VersionOrderingTimestamp:
+		ta, tb := findTimestamp(a), findTimestamp(b)
+		if ta == "" || tb == "" {
+			return 0
+		}
+		// pad to compare dates with datetimes
+		ta += strings.Repeat("0", 14-len(ta))
+		tb += strings.Repeat("0", 14-len(tb))
+		return strings.Compare(ta, tb)
+	default:
+		va, err := semver.NewVersion(a)
+		if err != nil {
+			return 0
+		}
+		vb, err := semver.NewVersion(b)
+		if err != nil {
+			return 0
+		}
+		return va.Compare(vb)
+	}
+}
+
+func findTimestamp(version string) string {
+	m, err := timestampRegex.FindStringMatch(version)
+	if err != nil || m == nil {
+		return ""
+	}
+	return m.String()
+}
@@ -0,0 +1,35 @@
+package flow
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindNewerVersion(t *testing.T) {
+	testcases := []struct {
+		name        string
+		ordering    string
+		oldVersions []string
+		version     string
+		newer       string
+		found       bool
+	}{
+		{name: "semver upgrade", ordering: "", oldVersions: []string{"v1.2.3"}, version: "v1.2.4", found: false},
+		{name: "semver downgrade", ordering: VersionOrderingSemver, oldVersions: []string{"v1.2.3"}, version: "v1.2.0", newer: "v1.2.3", found: true},
+		{name: "semver same", ordering: VersionOrderingSemver, oldVersions: []string{"v1.2.3"}, version: "1.2.3", found: false},
+		{name: "semver pre-release is older", ordering: VersionOrderingSemver, oldVersions: []string{"v1.2.3"}, version: "v1.2.3-rc.1", newer: "v1.2.3", found: true},
+		{name: "semver unordered", ordering: VersionOrderingSemver, oldVersions: []string{"abcdef1"}, version: "v1.2.3", found: false},
+		{name: "timestamp upgrade", ordering: VersionOrderingTimestamp, oldVersions: []string{"release-20261017-abcdef1"}, version: "release-20261018-1234567", found: false},
+		{name: "timestamp downgrade", ordering: VersionOrderingTimestamp, oldVersions: []string{"release-20261018-abcdef1"}, version: "release-20261017-1234567", newer: "release-20261018-abcdef1", found: true},
+		{name: "timestamp with time", ordering: VersionOrderingTimestamp, oldVersions: []string{"20261018"}, version: "20261018093000", found: false},
+		{name: "timestamp unordered", ordering: VersionOrderingTimestamp, oldVersions: []string{"latest"}, version: "release-20261017", found: false},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			newer, found := findNewerVersion(tc.ordering, tc.oldVersions, tc.version)
+			assert.Equal(t, tc.found, found)
+			assert.Equal(t, tc.newer, newer)
+		})
+	}
+}
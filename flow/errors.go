@@ -0,0 +1,75 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/google/go-github/v75/github"
+)
+
+// IsTransient reports whether err may go away by trying again later, such as GitHub outages,
+// rate limits and network failures. Other errors, such as a missing application for the image,
+// fail the same way every time.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	// joined errors are transient if any of them is, as trying again is worth it
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			if IsTransient(e) {
+				return true
+			}
+		}
+		return false
+	}
+	var rateLimitErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	var acceptedErr *github.AcceptedError
+	if errors.As(err, &rateLimitErr) || errors.As(err, &abuseErr) || errors.As(err, &acceptedErr) {
+		return true
+	}
+	var responseErr *github.ErrorResponse
+	if errors.As(err, &responseErr) && responseErr.Response != nil {
+		status := responseErr.Response.StatusCode
+		if status >= http.StatusInternalServerError || status == http.StatusTooManyRequests {
+			return true
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// failureReason returns the reason of the failure for metrics, such as rate_limited or network.
+func failureReason(err error) string {
+	var rateLimitErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	var responseErr *github.ErrorResponse
+	var netErr net.Error
+	switch {
+	case errors.As(err, &rateLimitErr) || errors.As(err, &abuseErr):
+		return "rate_limited"
+	case errors.Is(err, ErrApplicationNotFound):
+		return "no_application"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &responseErr) && responseErr.Response != nil:
+		if responseErr.Response.StatusCode == http.StatusTooManyRequests {
+			return "rate_limited"
+		}
+		if responseErr.Response.StatusCode >= http.StatusInternalServerError {
+			return "github_server_error"
+		}
+		return "github_client_error"
+	case errors.As(err, &netErr):
+		return "network"
+	default:
+		return "other"
+	}
+}
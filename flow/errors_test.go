@@ -0,0 +1,68 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v75/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTransient(t *testing.T) {
+	responseErr := func(status int) error {
+		return &github.ErrorResponse{Response: &http.Response{StatusCode: status}}
+	}
+
+	testcases := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{name: "nil", err: nil, transient: false},
+		{name: "server error", err: responseErr(http.StatusBadGateway), transient: true},
+		{name: "too many requests", err: responseErr(http.StatusTooManyRequests), transient: true},
+		{name: "not found", err: responseErr(http.StatusNotFound), transient: false},
+		{name: "wrapped server error", err: fmt.Errorf("failed to fetch a.yaml: %w", responseErr(http.StatusInternalServerError)), transient: true},
+		{name: "rate limit", err: &github.RateLimitError{}, transient: true},
+		{name: "secondary rate limit", err: &github.AbuseRateLimitError{}, transient: true},
+		{name: "network", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, transient: true},
+		{name: "deadline", err: context.DeadlineExceeded, transient: true},
+		{name: "application not found", err: fmt.Errorf("%w for image foo", ErrApplicationNotFound), transient: false},
+		{name: "joined", err: errors.Join(responseErr(http.StatusNotFound), responseErr(http.StatusServiceUnavailable)), transient: true},
+		{name: "joined permanent", err: errors.Join(ErrApplicationNotFound, responseErr(http.StatusUnprocessableEntity)), transient: false},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.transient, IsTransient(tc.err))
+		})
+	}
+}
+
+func TestFailureReason(t *testing.T) {
+	responseErr := func(status int) error {
+		return &github.ErrorResponse{Response: &http.Response{StatusCode: status}}
+	}
+
+	testcases := []struct {
+		err    error
+		reason string
+	}{
+		{err: &github.RateLimitError{}, reason: "rate_limited"},
+		{err: responseErr(http.StatusTooManyRequests), reason: "rate_limited"},
+		{err: fmt.Errorf("%w for image foo", ErrApplicationNotFound), reason: "no_application"},
+		{err: fmt.Errorf("failed to fetch a.yaml: %w", responseErr(http.StatusBadGateway)), reason: "github_server_error"},
+		{err: responseErr(http.StatusUnprocessableEntity), reason: "github_client_error"},
+		{err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, reason: "network"},
+		{err: context.DeadlineExceeded, reason: "timeout"},
+		{err: errors.New("invalid PR URL format"), reason: "other"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.reason, func(t *testing.T) {
+			assert.Equal(t, tc.reason, failureReason(tc.err))
+		})
+	}
+}
@@ -7,8 +7,13 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/sakajunquality/cloud-pubsub-events/gcrevent"
+	"github.com/ubie-oss/flow/v4/dedup"
+	"github.com/ubie-oss/flow/v4/history"
+	"github.com/ubie-oss/flow/v4/notify"
 )
 
 var (
@@ -24,7 +29,30 @@ type Flow struct {
 	githubAppPrivateKey   *string
 	enableVersionQuote    bool
 	enableAutoMerge       bool
+	dryRun                bool
 	maxRetries            int
+	dedup                 dedup.Store
+	history               history.Store
+	notifier              *notify.Notifier
+	// repoLocks serializes the rollouts to each manifest repository to avoid races updating refs
+	repoLocks sync.Map
+}
+
+// Options are options of processing an event.
+type Options struct {
+	// DryRun renders the changes without pushing them to GitHub. It is always on if FLOW_DRY_RUN is true.
+	DryRun bool `json:"dry_run,omitempty"`
+	// Envs limits the manifests to roll out to the ones of the environments.
+	Envs []string `json:"envs,omitempty"`
+	// SourceSHA is the commit of the source repository the image was built from.
+	SourceSHA string `json:"source_sha,omitempty"`
+	// MessageID identifies the delivery of the event, such as the Pub/Sub message ID, to ignore redeliveries.
+	MessageID string `json:"message_id,omitempty"`
+	// ReceivedAt is when the event was received, to measure the time until it is rolled out.
+	ReceivedAt time.Time `json:"received_at,omitzero"`
+	// Retried tells that the caller tries the rollout again on transient failures, so they are
+	// not notified until the caller gives up and calls RecordFailure.
+	Retried bool `json:"-"`
 }
 
 func New(c *Config) (*Flow, error) {
@@ -35,8 +63,10 @@ func New(c *Config) (*Flow, error) {
 	githubAppID := os.Getenv("FLOW_GITHUB_APP_ID")
 	githubAppInstlationID := os.Getenv("FLOW_GITHUB_APP_INSTALLATION_ID")
 	githubAppPrivateKey := os.Getenv("FLOW_GITHUB_APP_PRIVATE_KEY")
+	// the yaml, kustomize and helm modes quote a version only when it would not be read as a string
 	f.enableVersionQuote = os.Getenv("FLOW_ENABLE_VERSION_QUOTE") == "true"
 	f.enableAutoMerge = os.Getenv("FLOW_ENABLE_AUTO_MERGE") == "true"
+	f.dryRun = os.Getenv("FLOW_DRY_RUN") == "true"
 	f.githubToken = &githubToken
 
 	// Set maxRetries: config file > environment variable > default (3)
@@ -50,6 +80,40 @@ func New(c *Config) (*Flow, error) {
 		}
 	}
 
+	// Set dedupSize: environment variable > default (10000)
+	dedupSize := defaultDedupSize
+	if dedupSizeEnv := os.Getenv("FLOW_DEDUP_SIZE"); dedupSizeEnv != "" {
+		if dedupSizeInt, err := strconv.Atoi(dedupSizeEnv); err == nil && dedupSizeInt > 0 {
+			dedupSize = dedupSizeInt
+		}
+	}
+	f.dedup = dedup.NewMemoryStore(dedupSize)
+	if dedupFile := os.Getenv("FLOW_DEDUP_FILE"); dedupFile != "" {
+		store, err := dedup.NewFileStore(dedupFile, dedupSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load FLOW_DEDUP_FILE: %w", err)
+		}
+		f.dedup = store
+	}
+
+	// Set historySize: environment variable > default (1000)
+	historySize := defaultHistorySize
+	if historySizeEnv := os.Getenv("FLOW_HISTORY_SIZE"); historySizeEnv != "" {
+		if historySizeInt, err := strconv.Atoi(historySizeEnv); err == nil && historySizeInt > 0 {
+			historySize = historySizeInt
+		}
+	}
+	f.history = history.NewMemoryStore(historySize)
+	if historyFile := os.Getenv("FLOW_HISTORY_FILE"); historyFile != "" {
+		store, err := history.NewBoltStore(historyFile, historySize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open FLOW_HISTORY_FILE: %w", err)
+		}
+		f.history = store
+	}
+
+	f.notifier = notify.New(nil)
+
 	if githubAppID != "" {
 		f.useApp = true
 
@@ -75,24 +139,56 @@ func New(c *Config) (*Flow, error) {
 	return f, nil
 }
 
+// ProcessImage rolls out the version of the image as if it was pushed to the registry,
+// and returns the result of each manifest.
+func (f *Flow) ProcessImage(ctx context.Context, image, version, digest string, opts Options) (Results, error) {
+	if image == "" || version == "" {
+		return nil, errors.New("image and version are required")
+	}
+	return f.processImage(ctx, image, version, digest, opts)
+}
+
 func (f *Flow) ProcessGCREvent(ctx context.Context, e gcrevent.Event) error {
+	results, err := f.ProcessGCREventWithOptions(ctx, e, Options{})
+	return errors.Join(err, results.Err())
+}
+
+// ProcessGCREventWithOptions processes the event and returns the result of each manifest.
+func (f *Flow) ProcessGCREventWithOptions(ctx context.Context, e gcrevent.Event, opts Options) (Results, error) {
+	rollouts, err := GCREventRollouts(e, opts)
+	if err != nil {
+		return nil, err
+	}
+	return f.ProcessRollouts(ctx, rollouts)
+}
+
+// GCREventRollouts returns the rollout of the tag inserted in the event, if any.
+func GCREventRollouts(e gcrevent.Event, opts Options) ([]Rollout, error) {
 	if e.Action != gcrevent.ActionInsert {
-		return nil
+		return nil, nil
 	}
 
 	if e.Tag == nil {
-		return nil
+		return nil, nil
 	}
 
 	parts := strings.Split(*e.Tag, ":")
 	if len(parts) < 2 {
-		return errors.New("invalid image tag or missing version")
+		return nil, errors.New("invalid image tag or missing version")
 	}
 	image, version := parts[0], parts[1]
 
 	if image == "" || version == "" {
-		return fmt.Errorf("image format invalid: %s", *e.Tag)
+		return nil, fmt.Errorf("image format invalid: %s", *e.Tag)
+	}
+
+	// the digest is given as host/path@sha256:...
+	var digest string
+	if e.Digest != nil {
+		if i := strings.LastIndex(*e.Digest, "@"); i >= 0 {
+			digest = (*e.Digest)[i+1:]
+		}
 	}
 
-	return f.processImage(ctx, image, version)
+	return []Rollout{{Image: image, Version: version, Digest: digest, Options: opts}}, nil
 }
@@ -0,0 +1,100 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/v75/github"
+)
+
+// ProcessGitHubEvent processes a webhook event from GitHub and returns the result of each manifest.
+func (f *Flow) ProcessGitHubEvent(ctx context.Context, event interface{}, opts Options) (Results, error) {
+	rollouts, err := GitHubEventRollouts(event, opts)
+	if err != nil {
+		return nil, err
+	}
+	return f.ProcessRollouts(ctx, rollouts)
+}
+
+// GitHubEventRollouts returns the rollouts of a webhook event from GitHub.
+// Published container packages in GitHub Container Registry are rolled out as the image and tag, and
+// published releases are rolled out as the tag of the images of the applications built from the repository.
+func GitHubEventRollouts(event interface{}, opts Options) ([]Rollout, error) {
+	switch e := event.(type) {
+	case *github.RegistryPackageEvent:
+		if e.GetAction() != "published" {
+			return nil, nil
+		}
+		image, tag, digest, err := parseRegistryPackage(e.GetRegistryPackage())
+		if err != nil {
+			return nil, err
+		}
+		// untagged pushes such as the ones of multi-arch manifests are not rolled out
+		if tag == "" {
+			return nil, nil
+		}
+		return []Rollout{{Image: image, Version: tag, Digest: digest, Options: opts}}, nil
+	case *github.ReleaseEvent:
+		if e.GetAction() != "published" {
+			return nil, nil
+		}
+		owner, name := e.GetRepo().GetOwner().GetLogin(), e.GetRepo().GetName()
+		apps := getApplicationsBySource(owner, name)
+		if len(apps) == 0 {
+			return nil, fmt.Errorf("no application found for source %s/%s", owner, name)
+		}
+		var rollouts []Rollout
+		for _, app := range apps {
+			rollouts = append(rollouts, Rollout{Image: app.Image, Version: e.GetRelease().GetTagName(), Options: opts})
+		}
+		return rollouts, nil
+	default:
+		slog.Debug("Ignoring GitHub event", "type", fmt.Sprintf("%T", event))
+		return nil, nil
+	}
+}
+
+// parseRegistryPackage returns the image, tag and digest of a published container package,
+// e.g. ghcr.io/owner/name, v1.2.3 and sha256:...
+func parseRegistryPackage(p *github.Package) (image, tag, digest string, err error) {
+	switch strings.ToLower(p.GetPackageType()) {
+	case "container", "docker":
+	default:
+		return "", "", "", fmt.Errorf("unsupported package type %s", p.GetPackageType())
+	}
+
+	host := "ghcr.io"
+	if registryURL := p.GetRegistry().GetURL(); registryURL != "" {
+		u, err := url.Parse(registryURL)
+		if err == nil && u.Host != "" {
+			host = u.Host
+		}
+	}
+	if p.GetNamespace() == "" || p.GetName() == "" {
+		return "", "", "", errors.New("package namespace or name is missing")
+	}
+	image = strings.ToLower(fmt.Sprintf("%s/%s/%s", host, p.GetNamespace(), p.GetName()))
+
+	version := p.GetPackageVersion()
+	tag = version.GetContainerMetadata().GetTag().GetName()
+	digest = version.GetContainerMetadata().GetTag().GetDigest()
+	if digest == "" && strings.HasPrefix(version.GetVersion(), "sha256:") {
+		digest = version.GetVersion()
+	}
+	return image, tag, digest, nil
+}
+
+func getApplicationsBySource(owner, name string) []*Application {
+	var apps []*Application
+	for i := range cfg.ApplicationList {
+		app := &cfg.ApplicationList[i]
+		if strings.EqualFold(app.SourceOwner, owner) && strings.EqualFold(app.SourceName, name) {
+			apps = append(apps, app)
+		}
+	}
+	return apps
+}
@@ -0,0 +1,79 @@
+package flow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-github/v75/github"
+	"github.com/stretchr/testify/assert"
+)
+
+const registryPackagePublished = `{
+  "action": "published",
+  "registry_package": {
+    "id": 1,
+    "name": "Hello-World",
+    "namespace": "Octo-Org",
+    "ecosystem": "CONTAINER",
+    "package_type": "CONTAINER",
+    "package_version": {
+      "id": 2,
+      "version": "sha256:abcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcd",
+      "container_metadata": {
+        "tag": {
+          "name": "v1.2.3",
+          "digest": "sha256:abcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcd"
+        }
+      }
+    },
+    "registry": {
+      "name": "GitHub CR",
+      "url": "https://ghcr.io/octo-org",
+      "type": "docker"
+    }
+  }
+}`
+
+func TestParseRegistryPackage(t *testing.T) {
+	event, err := github.ParseWebHook("registry_package", []byte(registryPackagePublished))
+	assert.Nil(t, err)
+
+	image, tag, digest, err := parseRegistryPackage(event.(*github.RegistryPackageEvent).GetRegistryPackage())
+	assert.Nil(t, err)
+	assert.Equal(t, "ghcr.io/octo-org/hello-world", image)
+	assert.Equal(t, "v1.2.3", tag)
+	assert.Equal(t, "sha256:abcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcd", digest)
+
+	_, _, _, err = parseRegistryPackage(&github.Package{PackageType: github.Ptr("npm")})
+	assert.NotNil(t, err)
+}
+
+func TestProcessGitHubEvent(t *testing.T) {
+	cfg = &Config{
+		ApplicationList: []Application{
+			{Image: "ghcr.io/octo-org/hello-world", SourceOwner: "octo-org", SourceName: "hello-world"},
+			{Image: "ghcr.io/octo-org/hello-worker", SourceOwner: "Octo-Org", SourceName: "Hello-World"},
+			{Image: "ghcr.io/octo-org/other", SourceOwner: "octo-org", SourceName: "other"},
+		},
+	}
+	assert.Equal(t, []*Application{&cfg.ApplicationList[0], &cfg.ApplicationList[1]}, getApplicationsBySource("octo-org", "hello-world"))
+
+	f := &Flow{}
+	// events other than published ones are ignored
+	plans, err := f.ProcessGitHubEvent(context.Background(), &github.RegistryPackageEvent{Action: github.Ptr("updated")}, Options{})
+	assert.Nil(t, err)
+	assert.Nil(t, plans)
+	plans, err = f.ProcessGitHubEvent(context.Background(), &github.ReleaseEvent{Action: github.Ptr("created")}, Options{})
+	assert.Nil(t, err)
+	assert.Nil(t, plans)
+	plans, err = f.ProcessGitHubEvent(context.Background(), &github.PingEvent{}, Options{})
+	assert.Nil(t, err)
+	assert.Nil(t, plans)
+
+	_, err = f.ProcessGitHubEvent(context.Background(), &github.ReleaseEvent{
+		Action:  github.Ptr("published"),
+		Repo:    &github.Repository{Name: github.Ptr("unknown"), Owner: &github.User{Login: github.Ptr("octo-org")}},
+		Release: &github.RepositoryRelease{TagName: github.Ptr("v1.2.3")},
+	}, Options{})
+	assert.NotNil(t, err)
+}
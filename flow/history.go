@@ -0,0 +1,86 @@
+package flow
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/ubie-oss/flow/v4/gitbot"
+	"github.com/ubie-oss/flow/v4/history"
+)
+
+const defaultHistorySize = 1000
+
+// History returns the store of the rollouts pushed by flow.
+func (f *Flow) History() history.Store {
+	return f.history
+}
+
+// appName returns the name of the application, or the name of its source repository if it has none.
+func appName(a Application) string {
+	if a.Name != "" {
+		return a.Name
+	}
+	return a.SourceName
+}
+
+// newRecord returns the record of the rollout of the version to the manifest repository.
+func newRecord(app *Application, manifest Manifest, version, digest string, repo *gitbot.Repo, opts Options) *history.Record {
+	return &history.Record{
+		App:        appName(*app),
+		Image:      app.Image,
+		Tag:        version,
+		Digest:     digest,
+		Env:        manifest.Env,
+		Owner:      repo.SourceOwner,
+		Repo:       repo.SourceRepo,
+		BaseBranch: repo.BaseBranch,
+		MessageID:  opts.MessageID,
+		CreatedAt:  opts.ReceivedAt,
+	}
+}
+
+// recordStart marks the record running for the attempt.
+func (f *Flow) recordStart(ctx context.Context, rec *history.Record, attempt int, branch string) {
+	rec.Attempt = attempt
+	rec.Branch = branch
+	rec.Status = history.StatusRunning
+	rec.Error = ""
+	f.putRecord(ctx, rec)
+}
+
+// recordAttempt writes the outcome of an attempt to the record.
+func (f *Flow) recordAttempt(ctx context.Context, rec *history.Record, result Result, err error) {
+	updateRecord(rec, result, err)
+	f.putRecord(ctx, rec)
+}
+
+// updateRecord sets the status of the record from the outcome of an attempt.
+func updateRecord(rec *history.Record, result Result, err error) {
+	rec.Branch = result.Branch
+	rec.CommitSHA = result.CommitSHA
+	rec.PullRequestURL = result.PullRequestURL
+	rec.Skipped = result.Skipped
+	switch {
+	case err != nil:
+		rec.Status = history.StatusFailed
+		rec.Error = err.Error()
+	case result.Skipped != "":
+		rec.Status = history.StatusSkipped
+	case result.AutoMerged:
+		rec.Status = history.StatusMerged
+	case result.PullRequestURL != "":
+		rec.Status = history.StatusOpened
+	case result.CommitSHA != "":
+		rec.Status = history.StatusCommitted
+	}
+}
+
+// putRecord writes the record. Errors are logged, as failing to record a rollout must not fail it.
+func (f *Flow) putRecord(ctx context.Context, rec *history.Record) {
+	if f.history == nil {
+		return
+	}
+	if err := f.history.Put(ctx, rec); err != nil {
+		slog.Warn("Failed to record rollout", "env", rec.Env, "image", rec.Image, "version", rec.Tag, "error", err)
+	}
+}
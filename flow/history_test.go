@@ -0,0 +1,36 @@
+package flow
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ubie-oss/flow/v4/history"
+)
+
+func TestUpdateRecord(t *testing.T) {
+	tests := []struct {
+		name   string
+		result Result
+		err    error
+		want   history.Status
+	}{
+		{"failed", Result{CommitSHA: "abc"}, errors.New("error merging PR #1"), history.StatusFailed},
+		{"skipped", Result{Skipped: "downgrade from v2"}, nil, history.StatusSkipped},
+		{"merged", Result{CommitSHA: "abc", PullRequestURL: "https://github.com/o/r/pull/1", AutoMerged: true}, nil, history.StatusMerged},
+		{"opened", Result{CommitSHA: "abc", PullRequestURL: "https://github.com/o/r/pull/1"}, nil, history.StatusOpened},
+		{"committed", Result{CommitSHA: "abc"}, nil, history.StatusCommitted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &history.Record{Status: history.StatusRunning}
+			updateRecord(rec, tt.result, tt.err)
+			assert.Equal(t, tt.want, rec.Status)
+			assert.Equal(t, tt.result.CommitSHA, rec.CommitSHA)
+			assert.Equal(t, tt.result.PullRequestURL, rec.PullRequestURL)
+			if tt.err != nil {
+				assert.Equal(t, tt.err.Error(), rec.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package flow
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"slices"
+
+	"github.com/ubie-oss/flow/v4/history"
+	"github.com/ubie-oss/flow/v4/notify"
+)
+
+// notify sends the event to the notifications of the application and the manifest.
+// Errors are logged, as failing to notify must not fail the rollout.
+func (f *Flow) notify(ctx context.Context, app *Application, manifest Manifest, event notify.Event) {
+	if f.notifier == nil {
+		return
+	}
+	for _, n := range slices.Concat(app.Notifications, manifest.Notifications) {
+		target := newTarget(n)
+		if !target.Wants(event.Type) {
+			continue
+		}
+		if target.URL == "" {
+			slog.Warn("Notification URL is empty", "type", n.Type, "url_env", n.URLEnv)
+			continue
+		}
+		if err := f.notifier.Send(ctx, target, event); err != nil {
+			slog.Error("Failed to notify", "type", n.Type, "event", event.Type, "env", event.Env, "image", event.Image, "version", event.Tag, "error", err)
+		}
+	}
+}
+
+// newTarget returns the target of the notification, with the URL and secret read from the environment variables.
+func newTarget(n Notification) notify.Target {
+	target := notify.Target{
+		Type:     n.Type,
+		URL:      n.URL,
+		Events:   n.Events,
+		Template: n.Template,
+	}
+	if n.URLEnv != "" {
+		target.URL = os.Getenv(n.URLEnv)
+	}
+	if n.SecretEnv != "" {
+		target.Secret = os.Getenv(n.SecretEnv)
+	}
+	return target
+}
+
+// newEvent returns the event of the result of rolling out the version to a manifest.
+func newEvent(eventType string, app *Application, version string, result Result) notify.Event {
+	return notify.Event{
+		Type:           eventType,
+		App:            appName(*app),
+		Image:          app.Image,
+		Tag:            version,
+		Env:            result.Env,
+		Owner:          result.Owner,
+		Repo:           result.Repo,
+		PullRequestURL: result.PullRequestURL,
+		CommitSHA:      result.CommitSHA,
+		Error:          result.Error,
+	}
+}
+
+// notifyRecord sends the event of the recorded rollout, e.g. when its PR is merged.
+func (f *Flow) notifyRecord(ctx context.Context, eventType string, rec *history.Record) {
+	app, err := getApplicationByImage(rec.Image)
+	if err != nil {
+		return
+	}
+	for _, manifest := range app.Manifests {
+		if manifest.Env != rec.Env {
+			continue
+		}
+		f.notify(ctx, app, manifest, notify.Event{
+			Type:           eventType,
+			App:            rec.App,
+			Image:          rec.Image,
+			Tag:            rec.Tag,
+			Env:            rec.Env,
+			Owner:          rec.Owner,
+			Repo:           rec.Repo,
+			PullRequestURL: rec.PullRequestURL,
+			CommitSHA:      rec.CommitSHA,
+		})
+		return
+	}
+}
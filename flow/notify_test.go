@@ -0,0 +1,61 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ubie-oss/flow/v4/history"
+	"github.com/ubie-oss/flow/v4/notify"
+)
+
+func TestNotify(t *testing.T) {
+	var messages []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload struct {
+			Text string `json:"text"`
+		}
+		_ = json.Unmarshal(body, &payload)
+		messages = append(messages, r.URL.Path+" "+payload.Text)
+	}))
+	defer server.Close()
+	t.Setenv("TEST_SLACK_URL", server.URL+"/production")
+
+	app := Application{
+		Name:  "foo",
+		Image: "gcr.io/example/foo",
+		Notifications: []Notification{
+			{Type: notify.TypeSlack, URL: server.URL + "/all", Events: []string{notify.EventFailed}, Template: "{{.App}} failed in {{.Env}}"},
+		},
+		Manifests: []Manifest{
+			{Env: "dev"},
+			{Env: "production", Notifications: []Notification{
+				{Type: notify.TypeSlack, URLEnv: "TEST_SLACK_URL"},
+			}},
+		},
+	}
+	cfg = &Config{ApplicationList: []Application{app}}
+	ctx := context.Background()
+	f := &Flow{history: history.NewMemoryStore(10), notifier: notify.New(nil)}
+
+	result := Result{Env: "production", PullRequestURL: "https://github.com/ubie-oss/manifests/pull/1"}
+	f.notify(ctx, &app, app.Manifests[1], newEvent(notify.EventOpened, &app, "v1", result))
+	f.notify(ctx, &app, app.Manifests[0], newEvent(notify.EventFailed, &app, "v1", Result{Env: "dev"}))
+
+	// merged PRs are notified to the manifest of the record
+	rec := &history.Record{App: "foo", Image: app.Image, Tag: "v1", Env: "production", Owner: "ubie-oss", Repo: "manifests", Branch: "rollout/production-foo-v1-1", PullRequestURL: result.PullRequestURL, Status: history.StatusOpened}
+	assert.Nil(t, f.history.Put(ctx, rec))
+	_, err := f.TrackGitHubEvent(ctx, pullRequestEvent("closed", rec.Branch, true))
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{
+		"/production Opened a PR to roll out gcr.io/example/foo:v1 to production: https://github.com/ubie-oss/manifests/pull/1",
+		"/all foo failed in dev",
+		"/production Merged the rollout of gcr.io/example/foo:v1 to production: https://github.com/ubie-oss/manifests/pull/1",
+	}, messages)
+}
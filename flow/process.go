@@ -5,19 +5,101 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/dlclark/regexp2"
 	"github.com/google/go-github/v75/github"
 	"github.com/ubie-oss/flow/v4/gitbot"
+	"github.com/ubie-oss/flow/v4/history"
+	"github.com/ubie-oss/flow/v4/metrics"
+	"github.com/ubie-oss/flow/v4/notify"
+	"github.com/ubie-oss/flow/v4/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-type PullRequests []PullRequest
+// Result is the outcome of rolling out a version to a manifest.
+type Result struct {
+	Env            string `json:"env"`
+	Owner          string `json:"owner,omitempty"`
+	Repo           string `json:"repo,omitempty"`
+	Branch         string `json:"branch,omitempty"`
+	CommitSHA      string `json:"commit_sha,omitempty"`
+	PullRequestURL string `json:"pull_request_url,omitempty"`
+	AutoMerged     bool   `json:"auto_merged,omitempty"`
+	// Superseded are the URLs of the PRs of older versions closed in favor of this one
+	Superseded []string `json:"superseded,omitempty"`
+	// Skipped is the reason why nothing was pushed, if any
+	Skipped string `json:"skipped,omitempty"`
+	// Plan is set in the dry-run mode
+	Plan  *Plan  `json:"plan,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Transient is true if the error may go away by trying again later
+	Transient bool `json:"transient,omitempty"`
+
+	err error
+}
+
+func (r *Result) setError(err error) {
+	r.err = err
+	r.Error = err.Error()
+	r.Transient = IsTransient(err)
+}
+
+type Results []Result
+
+// Plans returns the plans rendered in the dry-run mode.
+func (rs Results) Plans() []Plan {
+	var plans []Plan
+	for _, r := range rs {
+		if r.Plan != nil {
+			plans = append(plans, *r.Plan)
+		}
+	}
+	return plans
+}
 
-type PullRequest struct {
-	env string
-	url string
+// Err returns the errors of the manifests which failed, or nil.
+func (rs Results) Err() error {
+	var errs []error
+	for _, r := range rs {
+		switch {
+		case r.err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", r.Env, r.err))
+		case r.Error != "":
+			errs = append(errs, fmt.Errorf("%s: %s", r.Env, r.Error))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Transient reports whether any of the manifests failed with a transient error.
+func (rs Results) Transient() bool {
+	for _, r := range rs {
+		if r.Transient {
+			return true
+		}
+	}
+	return false
+}
+
+// Plan is what would be pushed to the manifest repository in the dry-run mode.
+type Plan struct {
+	Env        string   `json:"env"`
+	Owner      string   `json:"owner"`
+	Repo       string   `json:"repo"`
+	BaseBranch string   `json:"base_branch"`
+	Branch     string   `json:"branch"`
+	Title      string   `json:"title"`
+	Body       string   `json:"body,omitempty"`
+	Labels     []string `json:"labels,omitempty"`
+	// Diffs are unified diffs keyed by the file paths
+	Diffs map[string]string `json:"diffs"`
 }
 
 const (
@@ -25,7 +107,9 @@ const (
 	// rewrite version but do not if there is comment "# do-not-rewrite" or "# no-rewrite"
 	versionRewriteRegex = "(?!.*(do-not-rewrite|no-rewrite).*)(version: +\"?(?<version>[a-zA-Z0-9-_+.]*)\"?)"
 	// the followings will be used with fmt.Sprintf and %s will be replaced
-	imageRewriteRegexTemplate            = "%s:(?<version>[a-zA-Z0-9-_+.]*)"
+	// a digest following the tag is replaced together
+	imageRewriteRegexTemplate            = "%s:(?<version>[a-zA-Z0-9-_+.]*)(@sha256:[a-f0-9]{64})?"
+	imageDigestRewriteRegexTemplate      = "%s@sha256:[a-f0-9]{64}"
 	additionalRewriteKeysRegexTemplate   = "%s: +\"?(?<version>[a-zA-Z0-9-_+.]*)\"?"
 	additionalRewritePrefixRegexTemplate = "%s(?<version>[a-zA-Z0-9-_+.]*)"
 )
@@ -33,18 +117,39 @@ const (
 // Merge commit regex.
 var mergeCommitRegex = regexp2.MustCompile("^Merge pull request #(?<number>\\d+) ", 0)
 
-func (f *Flow) processImage(ctx context.Context, image, version string) error {
+func (f *Flow) processImage(ctx context.Context, image, version, digest string, opts Options) (results Results, err error) {
 	app, err := getApplicationByImage(image)
 	if err != nil {
-		return err
+		// images of any package can be notified, so they are not recorded as labels
+		metrics.Failures.WithLabelValues("", "", failureReason(err)).Inc()
+		return nil, err
+	}
+	if opts.ReceivedAt.IsZero() {
+		opts.ReceivedAt = time.Now()
 	}
 
-	prs := f.process(ctx, app, version)
+	opts.DryRun = opts.DryRun || f.dryRun
+	if opts.MessageID != "" && !opts.DryRun {
+		key := messageKey(opts.MessageID, image)
+		if f.markSeen(ctx, key) {
+			slog.Info("Skipping duplicate message", "message_id", opts.MessageID, "image", image, "version", version)
+			return nil, nil
+		}
+		defer func() {
+			if results.Err() != nil {
+				f.forget(ctx, key)
+			}
+		}()
+	}
+	results = f.process(ctx, app, version, digest, opts)
 
-	for _, pr := range prs {
-		slog.Info("Processed PR", "url", pr.url)
+	for _, r := range results {
+		if r.PullRequestURL == "" {
+			continue
+		}
+		slog.Info("Processed PR", "url", r.PullRequestURL)
 	}
-	return nil
+	return results, nil
 }
 
 func (f *Flow) getGitbotClient(ctx context.Context) (*github.Client, error) {
@@ -54,114 +159,343 @@ func (f *Flow) getGitbotClient(ctx context.Context) (*github.Client, error) {
 	return gitbot.NewGitHubClient(ctx, *f.githubToken), nil
 }
 
-func (f *Flow) process(ctx context.Context, app *Application, version string) PullRequests {
-	var prs PullRequests
+func (f *Flow) process(ctx context.Context, app *Application, version, digest string, opts Options) (results Results) {
+	ctx, span := tracing.Start(ctx, "Flow.process",
+		tracing.MessageIDKey.String(opts.MessageID),
+		tracing.ImageKey.String(app.Image),
+		tracing.VersionKey.String(version),
+		attribute.Bool("flow.dry_run", opts.DryRun),
+	)
+	defer func() { tracing.End(span, results.Err()) }()
+
 	client, err := f.getGitbotClient(ctx)
 	if err != nil {
 		slog.Error("Failed to create GitHub client", "error", err)
-		return prs
+		result := Result{}
+		result.setError(err)
+		return Results{result}
 	}
 
 	for _, manifest := range app.Manifests {
+		if len(opts.Envs) > 0 && !slices.Contains(opts.Envs, manifest.Env) {
+			continue
+		}
 		if !shouldProcess(manifest, version) {
+			metrics.RolloutsFiltered.WithLabelValues(app.Image, manifest.Env).Inc()
 			continue
 		}
+		var key string
+		var rec *history.Record
+		unlock := func() {}
+		if !opts.DryRun {
+			repo := newRelease(*app, manifest, version, "").GetRepo()
+			key = rolloutKey(app.Image, version, manifest, repo)
+			if f.markSeen(ctx, key) {
+				slog.Info("Skipping rollout already processed", "env", manifest.Env, "image", app.Image, "version", version)
+				results = append(results, Result{Env: manifest.Env, Owner: repo.SourceOwner, Repo: repo.SourceRepo, Skipped: "already rolled out"})
+				continue
+			}
+			unlock = f.lockRepo(repo.SourceOwner, repo.SourceRepo)
+			rec = newRecord(app, manifest, version, digest, repo, opts)
+		}
+
+		var result Result
+		attempts := 0
 		for attempt := 1; attempt <= f.maxRetries; attempt++ {
-			err := f.processAttempt(ctx, client, app, manifest, version, attempt, &prs)
+			attempts = attempt
+			result, err = f.processAttempt(ctx, client, app, manifest, version, digest, opts, attempt, rec)
 			if err == nil {
 				break
 			}
 		}
+		unlock()
+		if !opts.DryRun {
+			metrics.Attempts.WithLabelValues(app.Image, manifest.Env).Observe(float64(attempts))
+			metrics.RolloutDuration.WithLabelValues(app.Image, manifest.Env).Observe(time.Since(opts.ReceivedAt).Seconds())
+		}
+		if err != nil {
+			slog.Error("Failed to roll out", "env", manifest.Env, "image", app.Image, "version", version, "transient", IsTransient(err), "error", err)
+			metrics.Failures.WithLabelValues(app.Image, manifest.Env, failureReason(err)).Inc()
+			result.setError(err)
+			if key != "" {
+				f.forget(ctx, key)
+			}
+			// canceled rollouts are not failures, as they are tried again after a restart
+			if !opts.DryRun && ctx.Err() == nil && !(opts.Retried && IsTransient(err)) {
+				event := newEvent(notify.EventFailed, app, version, result)
+				event.Attempts = attempts
+				f.notify(ctx, app, manifest, event)
+			}
+		}
+		results = append(results, result)
 	}
-	return prs
+	return results
 }
 
-func (f *Flow) processAttempt(ctx context.Context, client *github.Client, app *Application, manifest Manifest, version string, attempt int, prs *PullRequests) error {
+// processAttempt pushes the version to the manifest, and writes the outcome to rec unless it is nil in the dry-run mode.
+func (f *Flow) processAttempt(ctx context.Context, client *github.Client, app *Application, manifest Manifest, version, digest string, opts Options, attempt int, rec *history.Record) (result Result, err error) {
 	release := newRelease(*app, manifest, version, fmt.Sprintf("%d", attempt))
+	ctx, span := tracing.Start(ctx, "Flow.processAttempt",
+		tracing.EnvKey.String(manifest.Env),
+		tracing.RepoKey.String(release.GetRepo().SourceOwner+"/"+release.GetRepo().SourceRepo),
+		tracing.AttemptKey.Int(attempt),
+	)
+	defer func() { tracing.End(span, err) }()
+	result = Result{
+		Env:    manifest.Env,
+		Owner:  release.GetRepo().SourceOwner,
+		Repo:   release.GetRepo().SourceRepo,
+		Branch: release.GetRepo().CommitBranch,
+	}
+	if rec != nil {
+		f.recordStart(ctx, rec, attempt, result.Branch)
+		defer func() { f.recordAttempt(ctx, rec, result, err) }()
+	}
 
-	oldVersionSet := map[string]interface{}{}
-	for _, filePath := range manifest.Files {
-		release.MakeChangeFunc(ctx, client, filePath, fmt.Sprintf(imageRewriteRegexTemplate, app.Image), func(m regexp2.Match) string {
-			oldVersionSet[m.GroupByName("version").String()] = nil
-			return fmt.Sprintf("%s:%s", app.Image, version)
-		})
-		release.MakeChangeFunc(ctx, client, filePath, versionRewriteRegex, func(m regexp2.Match) string {
-			oldVersionSet[m.GroupByName("version").String()] = nil
-			if f.enableVersionQuote {
-				return fmt.Sprintf("version: \"%s\"", version)
-			}
-			return fmt.Sprintf("version: %s", version)
-		})
-
-		for _, key := range app.AdditionalRewriteKeys {
-			release.MakeChangeFunc(ctx, client, filePath, fmt.Sprintf(additionalRewriteKeysRegexTemplate, key), func(m regexp2.Match) string {
-				oldVersionSet[m.GroupByName("version").String()] = nil
-				if f.enableVersionQuote {
-					return fmt.Sprintf("%s: \"%s\"", key, version)
-				}
-				return fmt.Sprintf("%s: %s", key, version)
-			})
+	// a redelivered event must not open a second PR of the same rollout
+	if !opts.DryRun && !manifest.CommitWithoutPR && manifest.PRStrategy != PRStrategySingle {
+		pr, err := findOpenPullRequest(ctx, client, release.GetRepo(), getBranchName(*app, manifest, version), f.maxRetries)
+		if err != nil {
+			return result, err
 		}
-		for _, prefix := range app.AdditionalRewritePrefix {
-			release.MakeChangeFunc(ctx, client, filePath, fmt.Sprintf(additionalRewritePrefixRegexTemplate, prefix), func(m regexp2.Match) string {
-				oldVersionSet[m.GroupByName("version").String()] = nil
-				return fmt.Sprintf("%s%s", prefix, version)
-			})
+		if pr != nil {
+			slog.Info("Pull request already open", "env", manifest.Env, "image", app.Image, "version", version, "url", pr.GetHTMLURL())
+			result.Branch = pr.GetHead().GetRef()
+			result.PullRequestURL = pr.GetHTMLURL()
+			// merge the PR if auto-merge failed in an earlier attempt rather than skipping it silently
+			if f.enableAutoMerge && !gitbot.HasLabel(pr, manifest.DowngradeLabel) {
+				return result, f.mergePullRequest(ctx, client, app, manifest, version, &result)
+			}
+			result.Skipped = "pull request already open"
+			return result, nil
 		}
 	}
 
+	oldVersionSet := map[string]interface{}{}
+	for _, file := range manifest.Files {
+		f.rewriteFile(ctx, client, release, app, file, version, digest, oldVersionSet)
+	}
+	// do not push a partial change if some of the files could not be fetched or rewritten
+	if err := release.Err(); err != nil {
+		return result, err
+	}
+
 	oldVersions := []string{}
 	for oldVersion := range oldVersionSet {
 		oldVersions = append(oldVersions, oldVersion)
 	}
-	body := generateBody(ctx, client, app, manifest, version, oldVersions)
+
+	downgrade := false
+	if manifest.DowngradeLabel != "" {
+		// the single PR may have been flagged by an earlier downgrade
+		release.SetStaleLabels([]string{manifest.DowngradeLabel})
+	}
+	if manifest.ForbidDowngrade {
+		if newer, ok := findNewerVersion(manifest.VersionOrdering, oldVersions, version); ok {
+			if manifest.DowngradeLabel == "" || manifest.CommitWithoutPR {
+				slog.Warn("Skipping downgrade", "env", manifest.Env, "image", app.Image, "version", version, "current", newer)
+				result.Skipped = fmt.Sprintf("downgrade from %s", newer)
+				return result, nil
+			}
+			slog.Warn("Flagging downgrade", "env", manifest.Env, "image", app.Image, "version", version, "current", newer)
+			downgrade = true
+			release.SetLabels(append(release.GetLabels(), manifest.DowngradeLabel))
+		}
+	}
+
+	body := generateBody(ctx, client, app, manifest, version, digest, opts.SourceSHA, oldVersions)
+	if downgrade {
+		body = fmt.Sprintf("> [!WARNING]\n> This rolls back to %s from a newer version.\n\n%s", version, body)
+	}
 	release.SetBody(body)
 
-	err := release.Commit(ctx, client)
+	if opts.DryRun {
+		repo := release.GetRepo()
+		plan := &Plan{
+			Env:        manifest.Env,
+			Owner:      repo.SourceOwner,
+			Repo:       repo.SourceRepo,
+			BaseBranch: repo.BaseBranch,
+			Branch:     repo.CommitBranch,
+			Title:      release.GetMessage(),
+			Body:       release.GetBody(),
+			Labels:     release.GetLabels(),
+			Diffs:      release.Diffs(),
+		}
+		if manifest.CommitWithoutPR {
+			plan.Body = ""
+			plan.Labels = nil
+		}
+		slog.Info("Dry run", "env", plan.Env, "repo", plan.Owner+"/"+plan.Repo, "base_branch", plan.BaseBranch, "branch", plan.Branch,
+			"title", plan.Title, "labels", plan.Labels, "body", plan.Body, "diffs", plan.Diffs)
+		result.Plan = plan
+		return result, nil
+	}
+
+	err = release.Commit(ctx, client)
 	if err != nil {
 		slog.Error("Error committing", "error", err)
-		return err
+		return result, err
 	}
+	result.CommitSHA = release.GetCommitSHA()
+	metrics.Commits.WithLabelValues(app.Image, manifest.Env).Inc()
 
 	if !manifest.CommitWithoutPR {
-		url, err := release.CreatePR(ctx, client)
+		createPR := release.CreatePR
+		if manifest.PRStrategy == PRStrategySingle {
+			createPR = release.CreateOrUpdatePR
+		}
+		url, err := createPR(ctx, client)
 		if err != nil {
 			slog.Error("Error submitting PR", "error", err)
-			return err
+			return result, err
+		}
+		result.PullRequestURL = *url
+		metrics.PullRequests.WithLabelValues(app.Image, manifest.Env).Inc()
+
+		if manifest.SupersedePRs {
+			result.Superseded = supersedePullRequests(ctx, client, *app, manifest, release.GetRepo(), version, *url)
+		}
+
+		autoMerge := f.enableAutoMerge && !downgrade
+		if !autoMerge {
+			f.notify(ctx, app, manifest, newEvent(notify.EventOpened, app, version, result))
 		}
-		*prs = append(*prs, PullRequest{
-			env: manifest.Env,
-			url: *url,
-		})
 
-		if f.enableAutoMerge && url != nil {
-			parts := strings.Split(*url, "/")
-			// Extract repository owner and name from the URL
-			// URL format: https://github.com/{owner}/{repo}/pull/{number}
-			if len(parts) < 5 {
-				slog.Error("Invalid PR URL format", "url", *url)
-				return fmt.Errorf("invalid PR URL format: %s", *url)
+		if autoMerge {
+			if err := f.mergePullRequest(ctx, client, app, manifest, version, &result); err != nil {
+				// the PR is left open for humans unless a later attempt merges it
+				f.notify(ctx, app, manifest, newEvent(notify.EventOpened, app, version, result))
+				return result, err
 			}
-			prNumber, err := strconv.Atoi(parts[len(parts)-1])
-			if err != nil {
-				slog.Error("Error extracting PR number from URL", "url", *url, "error", err)
-				return fmt.Errorf("error extracting PR number from URL %s: %w", *url, err)
+		}
+	}
+	return result, nil
+}
+
+// mergePullRequest merges the PR of the result.
+func (f *Flow) mergePullRequest(ctx context.Context, client *github.Client, app *Application, manifest Manifest, version string, result *Result) error {
+	url := result.PullRequestURL
+	parts := strings.Split(url, "/")
+	// Extract repository owner and name from the URL
+	// URL format: https://github.com/{owner}/{repo}/pull/{number}
+	if len(parts) < 5 {
+		slog.Error("Invalid PR URL format", "url", url)
+		return fmt.Errorf("invalid PR URL format: %s", url)
+	}
+	prNumber, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		slog.Error("Error extracting PR number from URL", "url", url, "error", err)
+		return fmt.Errorf("error extracting PR number from URL %s: %w", url, err)
+	}
+	repoOwner := parts[len(parts)-4]
+	repoName := parts[len(parts)-3]
+
+	_, _, err = client.PullRequests.Merge(ctx, repoOwner, repoName, prNumber, "Auto-merged by flow", &github.PullRequestOptions{
+		MergeMethod: "squash",
+	})
+	if err != nil {
+		slog.Error("Error merging PR", "pr_number", prNumber, "error", err)
+		return fmt.Errorf("error merging PR #%d: %w", prNumber, err)
+	}
+	slog.Info("Successfully auto-merged PR", "pr_number", prNumber)
+	result.AutoMerged = true
+	metrics.AutoMerges.WithLabelValues(app.Image, manifest.Env).Inc()
+	f.notify(ctx, app, manifest, newEvent(notify.EventAutoMerged, app, version, *result))
+	return nil
+}
+
+// rewriteFile changes the file in the release to the version and records the versions it replaces.
+func (f *Flow) rewriteFile(ctx context.Context, client *github.Client, release gitbot.Release, app *Application, file File, version, digest string, oldVersionSet map[string]interface{}) {
+	pinDigest := app.PinDigest && digest != ""
+	tagEvaluator := func(value string) string {
+		if value != "" {
+			oldVersionSet[value] = nil
+		}
+		return version
+	}
+	var digestEvaluator gitbot.ValueEvaluator
+	if pinDigest {
+		digestEvaluator = func(string) string { return digest }
+	}
+
+	switch file.Mode {
+	case FileModeYAML:
+		release.MakeYAMLChangeFunc(ctx, client, file.Path, file.YAMLPaths, func(value string) string {
+			if ref, ok := strings.CutPrefix(value, app.Image+":"); ok {
+				oldVersion, _, _ := strings.Cut(ref, "@")
+				oldVersionSet[oldVersion] = nil
+				return imageReference(app, version, digest)
+			}
+			if strings.HasPrefix(value, app.Image+"@") {
+				return imageReference(app, version, digest)
 			}
-			repoOwner := parts[len(parts)-4]
-			repoName := parts[len(parts)-3]
-
-			_, _, err = client.PullRequests.Merge(ctx, repoOwner, repoName, prNumber, "Auto-merged by flow", &github.PullRequestOptions{
-				MergeMethod: "squash",
-			})
-			if err != nil {
-				slog.Error("Error merging PR", "pr_number", prNumber, "error", err)
-				return fmt.Errorf("error merging PR #%d: %w", prNumber, err)
-			} else {
-				slog.Info("Successfully auto-merged PR", "pr_number", prNumber)
+			// the paths can match the images of other containers, which are left as they are
+			if !isImageTag(value) {
+				return value
 			}
+			return tagEvaluator(value)
+		})
+		return
+	case FileModeKustomize:
+		release.MakeKustomizeChangeFunc(ctx, client, file.Path, app.Image, tagEvaluator, digestEvaluator)
+		return
+	case FileModeHelm:
+		release.MakeHelmChangeFunc(ctx, client, file.Path, app.Image, tagEvaluator, digestEvaluator)
+		return
+	}
+
+	filePath := file.Path
+	release.MakeChangeFunc(ctx, client, filePath, fmt.Sprintf(imageRewriteRegexTemplate, app.Image), func(m regexp2.Match) string {
+		oldVersionSet[m.GroupByName("version").String()] = nil
+		return imageReference(app, version, digest)
+	})
+	if pinDigest {
+		release.MakeChangeFunc(ctx, client, filePath, fmt.Sprintf(imageDigestRewriteRegexTemplate, app.Image), func(m regexp2.Match) string {
+			return imageReference(app, version, digest)
+		})
+	}
+	release.MakeChangeFunc(ctx, client, filePath, versionRewriteRegex, func(m regexp2.Match) string {
+		oldVersionSet[m.GroupByName("version").String()] = nil
+		if f.enableVersionQuote {
+			return fmt.Sprintf("version: \"%s\"", version)
 		}
+		return fmt.Sprintf("version: %s", version)
+	})
+
+	for _, key := range app.AdditionalRewriteKeys {
+		release.MakeChangeFunc(ctx, client, filePath, fmt.Sprintf(additionalRewriteKeysRegexTemplate, key), func(m regexp2.Match) string {
+			oldVersionSet[m.GroupByName("version").String()] = nil
+			if f.enableVersionQuote {
+				return fmt.Sprintf("%s: \"%s\"", key, version)
+			}
+			return fmt.Sprintf("%s: %s", key, version)
+		})
+	}
+	for _, prefix := range app.AdditionalRewritePrefix {
+		release.MakeChangeFunc(ctx, client, filePath, fmt.Sprintf(additionalRewritePrefixRegexTemplate, prefix), func(m regexp2.Match) string {
+			oldVersionSet[m.GroupByName("version").String()] = nil
+			return fmt.Sprintf("%s%s", prefix, version)
+		})
 	}
-	return nil
+}
+
+// isImageTag reports whether the value is a tag alone rather than a reference to an image,
+// which always has a repository, a tag or a digest separated by /, : or @.
+func isImageTag(value string) bool {
+	return !strings.ContainsAny(value, "/:@")
+}
+
+// imageReference returns the reference of the image written in manifests,
+// which is image:tag, image:tag@sha256:... or image@sha256:... depending on the application.
+func imageReference(app *Application, version, digest string) string {
+	if !app.PinDigest || digest == "" {
+		return fmt.Sprintf("%s:%s", app.Image, version)
+	}
+	if app.DigestOnly {
+		return fmt.Sprintf("%s@%s", app.Image, digest)
+	}
+	return fmt.Sprintf("%s:%s@%s", app.Image, version, digest)
 }
 
 func shouldProcess(m Manifest, version string) bool {
@@ -177,8 +511,22 @@ func shouldProcess(m Manifest, version string) bool {
 			return false
 		}
 	}
+	for _, pattern := range m.Filters.ExcludePatterns {
+		if matchPattern(pattern, version) != nil {
+			return false
+		}
+	}
+	for _, glob := range m.Filters.ExcludeGlobs {
+		if matchGlob(glob, version) {
+			return false
+		}
+	}
+
+	if m.Filters.Semver != "" && !matchSemver(m.Filters, version) {
+		return false
+	}
 
-	if len(m.Filters.IncludePrefixes) == 0 {
+	if len(m.Filters.IncludePrefixes) == 0 && len(m.Filters.IncludePatterns) == 0 && len(m.Filters.IncludeGlobs) == 0 {
 		return true
 	}
 
@@ -187,12 +535,128 @@ func shouldProcess(m Manifest, version string) bool {
 			return true
 		}
 	}
+	for _, pattern := range m.Filters.IncludePatterns {
+		if matchPattern(pattern, version) != nil {
+			return true
+		}
+	}
+	for _, glob := range m.Filters.IncludeGlobs {
+		if matchGlob(glob, version) {
+			return true
+		}
+	}
 
 	return false
 }
 
+// patterns caches the compiled regular expressions of the filters by their patterns.
+// They are compiled when the config is loaded, or on their first use for configs built otherwise.
+var patterns sync.Map
+
+// compilePattern returns the compiled regular expression of the pattern, compiling it only once.
+func compilePattern(pattern string) (*regexp2.Regexp, error) {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp2.Regexp), nil
+	}
+	re, err := regexp2.Compile(pattern, 0)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(pattern, re)
+	return re, nil
+}
+
+// matchPattern returns the match of the regular expression against version, or nil.
+func matchPattern(pattern, version string) *regexp2.Match {
+	re, err := compilePattern(pattern)
+	if err != nil {
+		slog.Error("Invalid tag pattern", "pattern", pattern, "error", err)
+		return nil
+	}
+	m, err := re.FindStringMatch(version)
+	if err != nil {
+		slog.Error("Error matching tag pattern", "pattern", pattern, "error", err)
+		return nil
+	}
+	return m
+}
+
+func matchGlob(glob, version string) bool {
+	ok, err := path.Match(glob, version)
+	if err != nil {
+		slog.Error("Invalid tag glob", "glob", glob, "error", err)
+		return false
+	}
+	return ok
+}
+
+// tagCaptures returns the named groups captured from version by the first matching include pattern.
+func tagCaptures(m Manifest, version string) map[string]string {
+	captures := map[string]string{}
+	for _, pattern := range m.Filters.IncludePatterns {
+		match := matchPattern(pattern, version)
+		if match == nil {
+			continue
+		}
+		for _, group := range match.Groups() {
+			if _, err := strconv.Atoi(group.Name); err == nil {
+				// skip unnamed groups
+				continue
+			}
+			captures[group.Name] = group.String()
+		}
+		break
+	}
+	return captures
+}
+
+var templateVariableRegex = regexp2.MustCompile(`\$\{(?<name>[a-zA-Z_][a-zA-Z0-9_]*)\}`, 0)
+
+// expandTemplate replaces ${name} with version, env or a named group captured from version.
+// Unknown variables are left as they are.
+func expandTemplate(text string, m Manifest, version string) string {
+	if !strings.Contains(text, "${") {
+		return text
+	}
+	vars := tagCaptures(m, version)
+	vars["version"] = version
+	vars["env"] = m.Env
+
+	result, err := templateVariableRegex.ReplaceFunc(text, func(match regexp2.Match) string {
+		if v, ok := vars[match.GroupByName("name").String()]; ok {
+			return v
+		}
+		return match.String()
+	}, 0, -1)
+	if err != nil {
+		return text
+	}
+	return result
+}
+
+// matchSemver reports whether version satisfies the semver constraint of the filters.
+// A leading "v" is tolerated and build metadata is ignored as the spec requires.
+func matchSemver(filters Filters, version string) bool {
+	constraint, err := semver.NewConstraint(filters.Semver)
+	if err != nil {
+		slog.Error("Invalid semver constraint", "constraint", filters.Semver, "error", err)
+		return false
+	}
+	constraint.IncludePrerelease = filters.IncludePrerelease
+
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return false
+	}
+	return constraint.Check(v)
+}
+
 func newRelease(app Application, manifest Manifest, version, branchSuffix string) gitbot.Release {
 	branchName := fmt.Sprintf("%s-%s", getBranchName(app, manifest, version), branchSuffix)
+	singlePR := manifest.PRStrategy == PRStrategySingle && !manifest.CommitWithoutPR
+	if singlePR {
+		branchName = getSingleBranchName(app, manifest, version)
+	}
 	message := getCommitMessage(app, manifest, version)
 
 	// Use base a branch configured in app level
@@ -245,6 +709,7 @@ func newRelease(app Application, manifest Manifest, version, branchSuffix string
 			SourceRepo:   manifestName,
 			BaseBranch:   baseBranch,
 			CommitBranch: commitBranch,
+			ForceUpdate:  singlePR,
 		},
 		gitbot.Author{
 			Name:  cfg.GitAuthor.Name,
@@ -257,6 +722,22 @@ func newRelease(app Application, manifest Manifest, version, branchSuffix string
 }
 
 func getBranchName(a Application, m Manifest, version string) string {
+	if m.BranchName != "" {
+		return expandTemplate(m.BranchName, m, version)
+	}
+	return getBranchPrefix(a, m) + "-" + version
+}
+
+// getSingleBranchName returns the long-lived branch of the PR strategy "single", rollout/<env>-<app> by default.
+func getSingleBranchName(a Application, m Manifest, version string) string {
+	if m.BranchName != "" {
+		return expandTemplate(m.BranchName, m, version)
+	}
+	return getBranchPrefix(a, m)
+}
+
+// getBranchPrefix returns the branch name without the version, such as rollout/<env>-<app>.
+func getBranchPrefix(a Application, m Manifest) string {
 	branch := "rollout/"
 	branch += m.Env
 
@@ -272,12 +753,14 @@ func getBranchName(a Application, m Manifest, version string) string {
 			branch += "-" + repo
 		}
 	}
-
-	branch += "-" + version
 	return branch
 }
 
 func getCommitMessage(a Application, m Manifest, version string) string {
+	if m.CommitMessage != "" {
+		return expandTemplate(m.CommitMessage, m, version)
+	}
+
 	message := "Rollout"
 	message += " " + m.Env
 
@@ -298,30 +781,50 @@ func getCommitMessage(a Application, m Manifest, version string) string {
 	return message
 }
 
+// ErrApplicationNotFound is returned when no application is configured for an image.
+var ErrApplicationNotFound = errors.New("no application found")
+
 func getApplicationByImage(image string) (*Application, error) {
 	for _, app := range cfg.ApplicationList {
 		if image == app.Image {
 			return &app, nil
 		}
 	}
-	return nil, errors.New("No application found for image " + image)
+	return nil, fmt.Errorf("%w for image %s", ErrApplicationNotFound, image)
 }
 
-func generateBody(ctx context.Context, client *github.Client, app *Application, manifest Manifest, version string, oldVersions []string) string {
+// generateBody returns the PR body. The changes are compared up to sourceSHA if it is given, or the version.
+func generateBody(ctx context.Context, client *github.Client, app *Application, manifest Manifest, version, digest, sourceSHA string, oldVersions []string) string {
+	ctx, span := tracing.Start(ctx, "generateBody", attribute.StringSlice("flow.old_versions", oldVersions))
+	defer span.End()
+
 	var body string
 
+	if app.PinDigest && digest != "" {
+		body += "# Image\n"
+		body += fmt.Sprintf("- Tag: `%s`\n", version)
+		body += fmt.Sprintf("- Digest: `%s`\n", digest)
+		body += "\n"
+	}
+
 	if !manifest.HideSourceReleaseDesc {
 		body += "# Release\n"
 		body += fmt.Sprintf("https://github.com/%s/%s/releases/tag/%s\n", app.SourceOwner, app.SourceName, version)
 		body += "\n"
 
+		head := version
+		if sourceSHA != "" {
+			body += fmt.Sprintf("Commit: https://github.com/%s/%s/commit/%s\n\n", app.SourceOwner, app.SourceName, sourceSHA)
+			head = sourceSHA
+		}
+
 		body += "## Changes\n\n"
 		for _, oldVersion := range oldVersions {
-			body += fmt.Sprintf("https://github.com/%s/%s/compare/%s...%s\n\n", app.SourceOwner, app.SourceName, oldVersion, version)
+			body += fmt.Sprintf("https://github.com/%s/%s/compare/%s...%s\n\n", app.SourceOwner, app.SourceName, oldVersion, head)
 			if !manifest.HideSourceReleasePullRequests {
 				body += "### Pull Requests\n\n"
 				prNumbers := []int{}
-				cmp, _, err := client.Repositories.CompareCommits(ctx, app.SourceOwner, app.SourceName, oldVersion, version, nil)
+				cmp, _, err := client.Repositories.CompareCommits(ctx, app.SourceOwner, app.SourceName, oldVersion, head, nil)
 				if err != nil {
 					slog.Error("Error comparing commits", "error", err)
 					continue
@@ -358,7 +861,7 @@ func generateBody(ctx context.Context, client *github.Client, app *Application,
 	}
 
 	if manifest.PRBody != "" {
-		body += fmt.Sprintf("\n---\n%s", manifest.PRBody)
+		body += fmt.Sprintf("\n---\n%s", expandTemplate(manifest.PRBody, manifest, version))
 	}
 
 	return body
@@ -3,11 +3,20 @@ package flow
 import (
 	// "regexp"
 
+	"context"
+	"encoding/base64"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
 	"testing"
 
 	"github.com/dlclark/regexp2"
+	"github.com/google/go-github/v75/github"
 	"github.com/stretchr/testify/assert"
+	"github.com/ubie-oss/flow/v4/gitbot"
+	"github.com/ubie-oss/flow/v4/notify"
 )
 
 func TestNewRelease(t *testing.T) {
@@ -83,6 +92,33 @@ func TestNewRelease(t *testing.T) {
 	assert.Equal(t, "master", r8.GetRepo().BaseBranch)
 }
 
+func TestNewReleaseWithSinglePR(t *testing.T) {
+	cfg = &Config{}
+
+	app := Application{
+		SourceOwner: "wonderland",
+		SourceName:  "alice",
+	}
+	manifest := Manifest{
+		Env:        "production",
+		PRStrategy: PRStrategySingle,
+	}
+
+	r := newRelease(app, manifest, "bar", "2")
+	assert.Equal(t, "Rollout production alice bar", r.GetMessage())
+	assert.Equal(t, "rollout/production-alice", r.GetRepo().CommitBranch)
+	assert.True(t, r.GetRepo().ForceUpdate)
+
+	manifest.BranchName = "deploy/${env}"
+	r = newRelease(app, manifest, "bar", "1")
+	assert.Equal(t, "deploy/production", r.GetRepo().CommitBranch)
+
+	manifest.PRStrategy = PRStrategyNew
+	r = newRelease(app, manifest, "bar", "1")
+	assert.Equal(t, "deploy/production-1", r.GetRepo().CommitBranch)
+	assert.False(t, r.GetRepo().ForceUpdate)
+}
+
 func TestNewReleaseForDefaultOrg(t *testing.T) {
 	cfg = &Config{
 		DefaultManifestOwner: "foo-inc",
@@ -165,6 +201,90 @@ func TestShouldProcess(t *testing.T) {
 	assert.Equal(t, false, shouldProcess(m3, "release-foo"))
 }
 
+func TestShouldProcessSemver(t *testing.T) {
+	testcases := []struct {
+		name     string
+		filters  Filters
+		version  string
+		expected bool
+	}{
+		{name: "in range", filters: Filters{Semver: ">=2.4.0 <3"}, version: "2.4.0", expected: true},
+		{name: "in range with v prefix", filters: Filters{Semver: ">=2.4.0 <3"}, version: "v2.10.1", expected: true},
+		{name: "below range", filters: Filters{Semver: ">=2.4.0 <3"}, version: "v2.3.9", expected: false},
+		{name: "above range", filters: Filters{Semver: ">=2.4.0 <3"}, version: "v3.0.0", expected: false},
+		{name: "pre-release excluded", filters: Filters{Semver: ">=2.4.0 <3"}, version: "v2.5.0-rc.1", expected: false},
+		{name: "pre-release included", filters: Filters{Semver: ">=2.4.0 <3", IncludePrerelease: true}, version: "v2.5.0-rc.1", expected: true},
+		{name: "pre-release in constraint", filters: Filters{Semver: ">=2.5.0-0 <3"}, version: "v2.5.0-rc.1", expected: true},
+		{name: "build metadata ignored", filters: Filters{Semver: ">=2.4.0 <3"}, version: "v2.4.0+build.7", expected: true},
+		{name: "or constraint", filters: Filters{Semver: "~1.2 || ^2.0"}, version: "1.2.9", expected: true},
+		{name: "not a semver", filters: Filters{Semver: ">=2.4.0"}, version: "release-foo", expected: false},
+		{name: "invalid constraint", filters: Filters{Semver: ">>2"}, version: "v2.4.0", expected: false},
+		{name: "with include prefix", filters: Filters{Semver: "^2", IncludePrefixes: []string{"v"}}, version: "2.4.0", expected: false},
+		{name: "with exclude prefix", filters: Filters{Semver: "^2", ExcludePrefixes: []string{"v2.4"}}, version: "v2.4.1", expected: false},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, shouldProcess(Manifest{Filters: tc.filters}, tc.version))
+		})
+	}
+}
+
+func TestShouldProcessPatterns(t *testing.T) {
+	testcases := []struct {
+		name     string
+		filters  Filters
+		version  string
+		expected bool
+	}{
+		{name: "include pattern", filters: Filters{IncludePatterns: []string{`^release-\d{8}-[0-9a-f]{7}$`}}, version: "release-20261018-abcdef1", expected: true},
+		{name: "include pattern mismatch", filters: Filters{IncludePatterns: []string{`^release-\d{8}-[0-9a-f]{7}$`}}, version: "release-foo", expected: false},
+		{name: "exclude pattern", filters: Filters{ExcludePatterns: []string{`-dirty$`}}, version: "qa-ABC-1-abcdef1-dirty", expected: false},
+		{name: "include glob", filters: Filters{IncludeGlobs: []string{"qa-*-*"}}, version: "qa-ABC-1-abcdef1", expected: true},
+		{name: "exclude glob", filters: Filters{IncludeGlobs: []string{"qa-*"}, ExcludeGlobs: []string{"qa-test-*"}}, version: "qa-test-abcdef1", expected: false},
+		{name: "any include matches", filters: Filters{IncludePrefixes: []string{"v"}, IncludePatterns: []string{`^qa-`}}, version: "qa-ABC-1-abcdef1", expected: true},
+		{name: "invalid pattern", filters: Filters{IncludePatterns: []string{`(`}}, version: "qa", expected: false},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, shouldProcess(Manifest{Filters: tc.filters}, tc.version))
+		})
+	}
+}
+
+func TestCompilePattern(t *testing.T) {
+	re, err := compilePattern(`^qa-\d+$`)
+	assert.Nil(t, err)
+	// compiled once
+	again, err := compilePattern(`^qa-\d+$`)
+	assert.Nil(t, err)
+	assert.Same(t, re, again)
+
+	_, err = compilePattern(`(`)
+	assert.NotNil(t, err)
+}
+
+func TestExpandTemplate(t *testing.T) {
+	manifest := Manifest{
+		Env: "qa",
+		Filters: Filters{
+			IncludePatterns: []string{`^qa-(?<ticket>[A-Z]+-\d+)-(?<sha>[0-9a-f]{7})$`},
+		},
+		BranchName:    "rollout/${env}-${ticket}",
+		CommitMessage: "Rollout ${env} ${ticket} (${sha})",
+	}
+	app := Application{
+		SourceOwner: "foo-inc",
+		SourceName:  "bar",
+	}
+	version := "qa-ABC-123-abcdef1"
+
+	assert.Equal(t, map[string]string{"ticket": "ABC-123", "sha": "abcdef1"}, tagCaptures(manifest, version))
+	assert.Equal(t, "rollout/qa-ABC-123", getBranchName(app, manifest, version))
+	assert.Equal(t, "Rollout qa ABC-123 (abcdef1)", getCommitMessage(app, manifest, version))
+	assert.Equal(t, "see ABC-123, keep ${HOME} and $ticket", expandTemplate("see ${ticket}, keep ${HOME} and $ticket", manifest, version))
+	assert.Equal(t, "${ticket}", expandTemplate("${ticket}", manifest, "v1.0.0"))
+}
+
 func TestGetBranchName(t *testing.T) {
 	app := Application{
 		SourceOwner: "foo-inc",
@@ -230,6 +350,29 @@ func TestRegexTemplate(t *testing.T) {
 	assert.Equal(t, r3, fmt.Sprintf("%s%s", testPrefix, newVersion))
 }
 
+func TestImageReference(t *testing.T) {
+	const digest = "sha256:abcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcdeabcd"
+	app := &Application{Image: "gcr.io/foo/bar"}
+
+	assert.Equal(t, "gcr.io/foo/bar:v1", imageReference(app, "v1", digest))
+	app.PinDigest = true
+	assert.Equal(t, "gcr.io/foo/bar:v1", imageReference(app, "v1", ""))
+	assert.Equal(t, "gcr.io/foo/bar:v1@"+digest, imageReference(app, "v1", digest))
+	app.DigestOnly = true
+	assert.Equal(t, "gcr.io/foo/bar@"+digest, imageReference(app, "v1", digest))
+
+	// a pinned reference is replaced as a whole
+	image := regexp2.MustCompile(fmt.Sprintf(imageRewriteRegexTemplate, app.Image), 0)
+	r1, err := image.Replace("image: gcr.io/foo/bar:v0@"+digest+" # pinned", "gcr.io/foo/bar:v1", 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, "image: gcr.io/foo/bar:v1 # pinned", r1)
+
+	imageDigest := regexp2.MustCompile(fmt.Sprintf(imageDigestRewriteRegexTemplate, app.Image), 0)
+	r2, err := imageDigest.Replace("image: gcr.io/foo/bar@"+digest, "gcr.io/foo/bar@sha256:new", 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, "image: gcr.io/foo/bar@sha256:new", r2)
+}
+
 func TestVersionREwriteRegex(t *testing.T) {
 
 	testcases := []struct {
@@ -299,3 +442,186 @@ test:
 		})
 	}
 }
+
+func TestResults(t *testing.T) {
+	results := Results{
+		{Env: "dev", PullRequestURL: "https://github.com/foo/manifests/pull/1"},
+		{Env: "stg", Plan: &Plan{Env: "stg"}},
+		{Env: "prod", Error: "failed to push"},
+	}
+	assert.Equal(t, []Plan{{Env: "stg"}}, results.Plans())
+	assert.EqualError(t, results.Err(), "prod: failed to push")
+	assert.Nil(t, results[:2].Err())
+	assert.False(t, results.Transient())
+
+	var result Result
+	result.setError(fmt.Errorf("failed to fetch a.yaml: %w", &github.RateLimitError{}))
+	results = append(results, result)
+	assert.True(t, results.Transient())
+	assert.True(t, IsTransient(results.Err()))
+}
+
+// newFakeGitHub returns a client of a fake GitHub manifest repository ubie-oss/manifests,
+// in which merging the PR fails while mergeable is false.
+func newFakeGitHub(t *testing.T, mergeable *bool) (*github.Client, *[]string) {
+	var (
+		mu       sync.Mutex
+		requests []string
+		pulls    = "[]"
+	)
+	content := base64.StdEncoding.EncodeToString([]byte("image: gcr.io/example/foo:v0\n"))
+	mux := http.NewServeMux()
+	handle := func(pattern string, body func() string, status int) {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			requests = append(requests, pattern)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(body()))
+		})
+	}
+	static := func(body string) func() string { return func() string { return body } }
+	repo := "/repos/ubie-oss/manifests"
+	pr := `{"number": 1, "html_url": "https://github.com/ubie-oss/manifests/pull/1", "head": {"ref": "rollout/production-foo-v1-1", "repo": {"full_name": "ubie-oss/manifests"}}}`
+
+	mux.HandleFunc("GET "+repo+"/pulls", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, "GET "+repo+"/pulls?head="+r.URL.Query().Get("head"))
+		if r.URL.Query().Get("head") != "ubie-oss:rollout/production-foo-v1-1" {
+			_, _ = w.Write([]byte("[]"))
+			return
+		}
+		_, _ = w.Write([]byte(pulls))
+	})
+	handle("GET "+repo+"/contents/deployment.yaml", static(`{"type": "file", "encoding": "base64", "content": "`+content+`"}`), http.StatusOK)
+	handle("GET "+repo+"/git/ref/heads/main", static(`{"ref": "refs/heads/main", "object": {"sha": "base"}}`), http.StatusOK)
+	handle("GET "+repo+"/git/ref/heads/rollout/", static(`{"message": "Not Found"}`), http.StatusNotFound)
+	handle("POST "+repo+"/git/refs", static(`{"ref": "refs/heads/rollout/production-foo-v1-1", "object": {"sha": "base"}}`), http.StatusCreated)
+	handle("POST "+repo+"/git/trees", static(`{"sha": "tree"}`), http.StatusCreated)
+	handle("GET "+repo+"/commits/base", static(`{"sha": "base", "commit": {"sha": "base"}}`), http.StatusOK)
+	handle("POST "+repo+"/git/commits", static(`{"sha": "commit"}`), http.StatusCreated)
+	handle("PATCH "+repo+"/git/refs/heads/rollout/", static(`{"ref": "refs/heads/rollout/production-foo-v1-1", "object": {"sha": "commit"}}`), http.StatusOK)
+	handle("POST "+repo+"/pulls", func() string { pulls = "[" + pr + "]"; return pr }, http.StatusCreated)
+	handle("POST "+repo+"/issues/1/labels", static(`[]`), http.StatusOK)
+	mux.HandleFunc("PUT "+repo+"/pulls/1/merge", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, "PUT "+repo+"/pulls/1/merge")
+		if !*mergeable {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			_, _ = w.Write([]byte(`{"message": "Pull Request is not mergeable"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"merged": true}`))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	return client, &requests
+}
+
+func TestProcessAttemptAutoMergeFailure(t *testing.T) {
+	var events []string
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events = append(events, r.Header.Get(notify.EventHeader))
+	}))
+	defer slack.Close()
+
+	mergeable := false
+	client, requests := newFakeGitHub(t, &mergeable)
+	cfg = &Config{DefaultBranch: "main", GitAuthor: GitAuthor{Name: "test", Email: "test@test.test"}}
+	app := &Application{
+		Name:          "foo",
+		Image:         "gcr.io/example/foo",
+		SourceOwner:   "ubie-oss",
+		SourceName:    "foo",
+		ManifestOwner: "ubie-oss",
+		ManifestName:  "manifests",
+		Notifications: []Notification{{Type: notify.TypeWebhook, URL: slack.URL}},
+	}
+	manifest := Manifest{Env: "production", HideSourceReleaseDesc: true, Files: []File{{Path: "deployment.yaml"}}}
+	app.Manifests = []Manifest{manifest}
+	f := &Flow{enableAutoMerge: true, maxRetries: 3, notifier: notify.New(nil)}
+	ctx := context.Background()
+
+	// the PR is opened but not merged
+	result, err := f.processAttempt(ctx, client, app, manifest, "v1", "", Options{}, 1, nil)
+	assert.ErrorContains(t, err, "error merging PR #1")
+	assert.Equal(t, "https://github.com/ubie-oss/manifests/pull/1", result.PullRequestURL)
+	assert.False(t, result.AutoMerged)
+	assert.Equal(t, []string{notify.EventOpened}, events)
+
+	// the retry merges the PR opened by the first attempt instead of skipping it
+	result, err = f.processAttempt(ctx, client, app, manifest, "v1", "", Options{}, 2, nil)
+	assert.ErrorContains(t, err, "error merging PR #1")
+	assert.Empty(t, result.Skipped)
+	assert.Equal(t, []string{notify.EventOpened}, events)
+
+	mergeable = true
+	result, err = f.processAttempt(ctx, client, app, manifest, "v1", "", Options{}, 3, nil)
+	assert.Nil(t, err)
+	assert.True(t, result.AutoMerged)
+	assert.Equal(t, []string{notify.EventOpened, notify.EventAutoMerged}, events)
+
+	created := 0
+	for _, r := range *requests {
+		if r == "POST /repos/ubie-oss/manifests/pulls" {
+			created++
+		}
+		// open PRs are looked up by their branches
+		assert.NotEqual(t, "GET /repos/ubie-oss/manifests/pulls?head=", r)
+	}
+	assert.Equal(t, 1, created)
+}
+
+func TestRewriteFileYAML(t *testing.T) {
+	original := `spec:
+  template:
+    spec:
+      containers:
+        - name: app
+          image: gcr.io/example/foo:v0
+        - name: proxy
+          image: gcr.io/cloudsql-docker/gce-proxy:1.33
+      initContainers:
+        - name: migrate
+          image: v0
+`
+	content := base64.StdEncoding.EncodeToString([]byte(original))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"type": "file", "encoding": "base64", "content": "` + content + `"}`))
+	}))
+	defer server.Close()
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	app := &Application{Image: "gcr.io/example/foo"}
+	file := File{Path: "deployment.yaml", Mode: FileModeYAML, YAMLPaths: []string{"spec.template.spec.containers[*].image", "spec.template.spec.initContainers[*].image"}}
+	release := gitbot.NewRelease(gitbot.Repo{SourceOwner: "ubie-oss", SourceRepo: "manifests", BaseBranch: "main"}, gitbot.Author{}, "", "", nil)
+	oldVersionSet := map[string]interface{}{}
+	f := &Flow{}
+	f.rewriteFile(context.Background(), client, release, app, file, "v1", "", oldVersionSet)
+
+	assert.Nil(t, release.Err())
+	expected := `--- a/deployment.yaml
++++ b/deployment.yaml
+@@ -3,9 +3,9 @@
+     spec:
+       containers:
+         - name: app
+-          image: gcr.io/example/foo:v0
++          image: gcr.io/example/foo:v1
+         - name: proxy
+           image: gcr.io/cloudsql-docker/gce-proxy:1.33
+       initContainers:
+         - name: migrate
+-          image: v0
++          image: v1
+`
+	assert.Equal(t, map[string]string{"deployment.yaml": expected}, release.Diffs())
+	assert.Equal(t, map[string]interface{}{"v0": nil}, oldVersionSet)
+}
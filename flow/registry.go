@@ -0,0 +1,68 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+)
+
+// RegistryEnvelope is a notification sent by Docker Registry v2 / OCI distribution compatible registries.
+// See https://distribution.github.io/distribution/about/notifications/
+type RegistryEnvelope struct {
+	Events []RegistryEvent `json:"events"`
+}
+
+type RegistryEvent struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+	Target struct {
+		MediaType  string `json:"mediaType"`
+		Digest     string `json:"digest"`
+		Repository string `json:"repository"`
+		URL        string `json:"url"`
+		Tag        string `json:"tag"`
+	} `json:"target"`
+	Request struct {
+		Host string `json:"host"`
+	} `json:"request"`
+}
+
+func ParseRegistryEnvelope(data []byte) (RegistryEnvelope, error) {
+	var e RegistryEnvelope
+	err := json.Unmarshal(data, &e)
+	return e, err
+}
+
+// Image returns the image of the event, e.g. registry.example.com/team/app.
+func (e RegistryEvent) Image() string {
+	host := e.Request.Host
+	if host == "" {
+		if u, err := url.Parse(e.Target.URL); err == nil {
+			host = u.Host
+		}
+	}
+	if host == "" {
+		return e.Target.Repository
+	}
+	return host + "/" + e.Target.Repository
+}
+
+// ProcessRegistryEnvelope rolls out every tagged push in the envelope and returns the result of each manifest.
+func (f *Flow) ProcessRegistryEnvelope(ctx context.Context, envelope RegistryEnvelope, opts Options) (Results, error) {
+	return f.ProcessRollouts(ctx, RegistryEnvelopeRollouts(envelope, opts))
+}
+
+// RegistryEnvelopeRollouts returns the rollouts of the tagged pushes in the envelope.
+// Pushes of blobs and untagged manifests, and the other actions, are ignored.
+func RegistryEnvelopeRollouts(envelope RegistryEnvelope, opts Options) []Rollout {
+	var rollouts []Rollout
+	for _, e := range envelope.Events {
+		if e.Action != "push" || e.Target.Tag == "" || e.Target.Repository == "" {
+			continue
+		}
+		eventOpts := opts
+		eventOpts.MessageID = e.ID
+		rollouts = append(rollouts, Rollout{Image: e.Image(), Version: e.Target.Tag, Digest: e.Target.Digest, Options: eventOpts})
+	}
+	return rollouts
+}
@@ -0,0 +1,81 @@
+package flow
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRegistryEnvelope(t *testing.T) {
+	data, err := os.ReadFile("testdata/registry/push.json")
+	assert.Nil(t, err)
+
+	e, err := ParseRegistryEnvelope(data)
+	assert.Nil(t, err)
+	assert.Len(t, e.Events, 3)
+	assert.Equal(t, "registry.example.com:5000/team/app", e.Events[0].Image())
+	assert.Equal(t, "v1.2.3", e.Events[0].Target.Tag)
+	assert.Equal(t, "sha256:fea8895f450959fa676bcc1df0611ea93823a735a01205fd8622846041d0c7cf", e.Events[0].Target.Digest)
+	// the host is taken from the URL without the request host
+	assert.Equal(t, "registry.example.com:5000/team/worker", e.Events[1].Image())
+}
+
+func TestRegistryEnvelopeRollouts(t *testing.T) {
+	data, err := os.ReadFile("testdata/registry/push.json")
+	assert.Nil(t, err)
+	e, err := ParseRegistryEnvelope(data)
+	assert.Nil(t, err)
+
+	rollouts := RegistryEnvelopeRollouts(e, Options{DryRun: true})
+	assert.Len(t, rollouts, 2)
+	assert.Equal(t, Rollout{
+		Image:   "registry.example.com:5000/team/app",
+		Version: "v1.2.3",
+		Digest:  "sha256:fea8895f450959fa676bcc1df0611ea93823a735a01205fd8622846041d0c7cf",
+		Options: Options{DryRun: true, MessageID: "320678d8-ca14-430f-8bb6-4ca139cd83f7"},
+	}, rollouts[0])
+	assert.Equal(t, e.Events[1].ID, rollouts[1].Options.MessageID)
+}
+
+func TestProcessRegistryEnvelope(t *testing.T) {
+	cfg = &Config{}
+	f := &Flow{}
+
+	testcases := []struct {
+		fixture string
+		errors  []string
+	}{
+		{
+			fixture: "testdata/registry/push.json",
+			// every tagged push is dispatched, the blob is not
+			errors: []string{
+				"registry.example.com:5000/team/app:v1.2.3: no application found for image registry.example.com:5000/team/app",
+				"registry.example.com:5000/team/worker:v1.2.3: no application found for image registry.example.com:5000/team/worker",
+			},
+		},
+		{
+			fixture: "testdata/registry/pull.json",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.fixture, func(t *testing.T) {
+			data, err := os.ReadFile(tc.fixture)
+			assert.Nil(t, err)
+			e, err := ParseRegistryEnvelope(data)
+			assert.Nil(t, err)
+
+			_, err = f.ProcessRegistryEnvelope(context.Background(), e, Options{})
+			if len(tc.errors) == 0 {
+				assert.Nil(t, err)
+				return
+			}
+			var messages []string
+			for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+				messages = append(messages, err.Error())
+			}
+			assert.Equal(t, tc.errors, messages)
+		})
+	}
+}
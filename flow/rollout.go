@@ -0,0 +1,122 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/ubie-oss/flow/v4/history"
+	"github.com/ubie-oss/flow/v4/metrics"
+	"github.com/ubie-oss/flow/v4/notify"
+)
+
+// Rollout is a version of an image to roll out, taken from an event so that it can be queued.
+type Rollout struct {
+	Image   string  `json:"image"`
+	Version string  `json:"version"`
+	Digest  string  `json:"digest,omitempty"`
+	Options Options `json:"options"`
+}
+
+// ProcessRollouts processes the rollouts in order and returns the result of each manifest.
+// The errors are prefixed with the image and version of their rollouts.
+func (f *Flow) ProcessRollouts(ctx context.Context, rollouts []Rollout) (Results, error) {
+	var results Results
+	var errs []error
+	for _, r := range rollouts {
+		rs, err := f.ProcessImage(ctx, r.Image, r.Version, r.Digest, r.Options)
+		results = append(results, rs...)
+		if err != nil {
+			slog.Error("Failed to process rollout", "image", r.Image, "version", r.Version, "error", err)
+			errs = append(errs, fmt.Errorf("%s:%s: %w", r.Image, r.Version, err))
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// CountEvent counts the event received from the source by the image and env of each manifest its rollouts are for.
+// Events without rollouts of configured applications are counted once with empty labels,
+// as the images of any package can be notified.
+func CountEvent(source string, rollouts []Rollout) {
+	counted := false
+	for _, r := range rollouts {
+		app, err := getApplicationByImage(r.Image)
+		if err != nil {
+			continue
+		}
+		for _, manifest := range app.Manifests {
+			if len(r.Options.Envs) > 0 && !slices.Contains(r.Options.Envs, manifest.Env) {
+				continue
+			}
+			metrics.EventsReceived.WithLabelValues(source, app.Image, manifest.Env).Inc()
+			counted = true
+		}
+	}
+	if !counted {
+		metrics.EventsReceived.WithLabelValues(source, "", "").Inc()
+	}
+}
+
+// RecordFailure records the rollout failed in the manifests it did not roll out to and notifies them,
+// when the caller gives up trying it again after the attempts.
+func (f *Flow) RecordFailure(ctx context.Context, rollout Rollout, attempts int, err error) {
+	app, aerr := getApplicationByImage(rollout.Image)
+	if aerr != nil {
+		return
+	}
+	opts := rollout.Options
+	for _, manifest := range app.Manifests {
+		if len(opts.Envs) > 0 && !slices.Contains(opts.Envs, manifest.Env) {
+			continue
+		}
+		if !shouldProcess(manifest, rollout.Version) {
+			continue
+		}
+		repo := newRelease(*app, manifest, rollout.Version, "").GetRepo()
+		rec := f.findRecord(ctx, app, manifest, rollout)
+		if rec == nil {
+			rec = newRecord(app, manifest, rollout.Version, rollout.Digest, repo, opts)
+		} else if rec.Status != history.StatusFailed && rec.Status != history.StatusRunning {
+			continue
+		}
+		rec.Status = history.StatusFailed
+		if rec.Error == "" {
+			rec.Error = err.Error()
+		}
+		f.putRecord(ctx, rec)
+
+		result := Result{Env: manifest.Env, Owner: repo.SourceOwner, Repo: repo.SourceRepo, PullRequestURL: rec.PullRequestURL, Error: rec.Error}
+		event := newEvent(notify.EventFailed, app, rollout.Version, result)
+		event.Attempts = attempts
+		f.notify(ctx, app, manifest, event)
+	}
+}
+
+// findRecord returns the most recent record of the rollout to the manifest, or nil if there is none.
+func (f *Flow) findRecord(ctx context.Context, app *Application, manifest Manifest, rollout Rollout) *history.Record {
+	if f.history == nil {
+		return nil
+	}
+	records, err := f.history.List(ctx, history.Filter{Image: app.Image, Env: manifest.Env})
+	if err != nil {
+		slog.Warn("Failed to list rollouts", "env", manifest.Env, "image", app.Image, "error", err)
+		return nil
+	}
+	for _, rec := range records {
+		if rec.Tag == rollout.Version && rec.MessageID == rollout.Options.MessageID {
+			return &rec
+		}
+	}
+	return nil
+}
+
+// lockRepo blocks until no other rollout is pushing to the manifest repository, and returns the function to unlock it.
+func (f *Flow) lockRepo(owner, repo string) func() {
+	v, _ := f.repoLocks.LoadOrStore(owner+"/"+repo, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
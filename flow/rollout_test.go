@@ -0,0 +1,82 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/ubie-oss/flow/v4/history"
+	"github.com/ubie-oss/flow/v4/metrics"
+	"github.com/ubie-oss/flow/v4/notify"
+)
+
+func TestRecordFailure(t *testing.T) {
+	var notified []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notified = append(notified, r.URL.Path+" "+r.Header.Get(notify.EventHeader))
+	}))
+	defer server.Close()
+
+	app := Application{
+		Name:          "foo",
+		Image:         "gcr.io/example/foo",
+		ManifestOwner: "ubie-oss",
+		ManifestName:  "manifests",
+		Manifests: []Manifest{
+			{Env: "dev", Notifications: []Notification{{Type: notify.TypeWebhook, URL: server.URL + "/dev"}}},
+			{Env: "production", Notifications: []Notification{{Type: notify.TypeWebhook, URL: server.URL + "/production"}}},
+		},
+	}
+	cfg = &Config{ApplicationList: []Application{app}}
+	ctx := context.Background()
+	f := &Flow{history: history.NewMemoryStore(10), notifier: notify.New(nil)}
+
+	// production was rolled out by an earlier attempt
+	opened := &history.Record{App: "foo", Image: app.Image, Tag: "v1", Env: "production", MessageID: "1", Status: history.StatusOpened}
+	assert.Nil(t, f.history.Put(ctx, opened))
+
+	rollout := Rollout{Image: app.Image, Version: "v1", Options: Options{MessageID: "1"}}
+	f.RecordFailure(ctx, rollout, 5, errors.New("GitHub is unavailable"))
+
+	records, err := f.history.List(ctx, history.Filter{Image: app.Image, Env: "dev"})
+	assert.Nil(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, history.StatusFailed, records[0].Status)
+		assert.Equal(t, "GitHub is unavailable", records[0].Error)
+		assert.Equal(t, "v1", records[0].Tag)
+	}
+	records, err = f.history.List(ctx, history.Filter{Image: app.Image, Env: "production"})
+	assert.Nil(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, history.StatusOpened, records[0].Status)
+	}
+	assert.Equal(t, []string{"/dev " + notify.EventFailed}, notified)
+}
+
+func TestCountEvent(t *testing.T) {
+	cfg = &Config{ApplicationList: []Application{{
+		Image:     "gcr.io/example/foo",
+		Manifests: []Manifest{{Env: "dev"}, {Env: "production"}},
+	}}}
+	count := func(image, env string) float64 {
+		return testutil.ToFloat64(metrics.EventsReceived.WithLabelValues("test", image, env))
+	}
+
+	CountEvent("test", []Rollout{{Image: "gcr.io/example/foo", Version: "v1"}})
+	assert.Equal(t, float64(1), count("gcr.io/example/foo", "dev"))
+	assert.Equal(t, float64(1), count("gcr.io/example/foo", "production"))
+
+	CountEvent("test", []Rollout{{Image: "gcr.io/example/foo", Version: "v1", Options: Options{Envs: []string{"dev"}}}})
+	assert.Equal(t, float64(2), count("gcr.io/example/foo", "dev"))
+	assert.Equal(t, float64(1), count("gcr.io/example/foo", "production"))
+
+	// images of other packages are not recorded as labels
+	CountEvent("test", []Rollout{{Image: "gcr.io/example/bar", Version: "v1"}})
+	CountEvent("test", nil)
+	assert.Equal(t, float64(2), count("", ""))
+	assert.Equal(t, float64(0), count("gcr.io/example/bar", ""))
+}
@@ -0,0 +1,77 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v75/github"
+	"github.com/ubie-oss/flow/v4/gitbot"
+)
+
+// versionMarker stands for the version in branch names to find where the version is in them.
+const versionMarker = "\x00"
+
+// staleVersion returns the version of pr if it is a rollout of an older version of the application
+// to the manifest, i.e. both its branch and title are the ones flow generates for that version.
+// PRs of newer versions and of versions which cannot be ordered are kept, as events can come out of order.
+func staleVersion(app Application, manifest Manifest, pr *github.PullRequest, version string) (string, bool) {
+	branch := pr.GetHead().GetRef()
+	i := strings.LastIndex(branch, "-")
+	if i < 0 {
+		return "", false
+	}
+	if _, err := strconv.Atoi(branch[i+1:]); err != nil {
+		return "", false
+	}
+	branch = branch[:i]
+
+	prefix, suffix, ok := strings.Cut(getBranchName(app, manifest, versionMarker), versionMarker)
+	if !ok || len(branch) <= len(prefix)+len(suffix) || !strings.HasPrefix(branch, prefix) || !strings.HasSuffix(branch, suffix) {
+		return "", false
+	}
+	candidate := branch[len(prefix) : len(branch)-len(suffix)]
+	if candidate == version {
+		return "", false
+	}
+	// prefixes of other applications can match, e.g. rollout/dev-app- of rollout/dev-app-worker-1.0.0
+	if getBranchName(app, manifest, candidate) != branch || getCommitMessage(app, manifest, candidate) != pr.GetTitle() {
+		return "", false
+	}
+	if compareVersions(manifest.VersionOrdering, candidate, version) >= 0 {
+		return "", false
+	}
+	return candidate, true
+}
+
+// supersedePullRequests closes the open rollout PRs of the older versions of the application to the manifest
+// in favor of the PR at url, and returns the URLs of the closed ones. Failures are logged as the new PR is already open.
+func supersedePullRequests(ctx context.Context, client *github.Client, app Application, manifest Manifest, repo *gitbot.Repo, version, url string) []string {
+	// the branches of older versions are not known, and GitHub only filters PRs by exact branches, so all are listed
+	prs, err := gitbot.ListOpenPullRequests(ctx, client, repo.SourceOwner, repo.SourceRepo, repo.BaseBranch)
+	if err != nil {
+		slog.Error("Failed to list pull requests to supersede", "error", err)
+		return nil
+	}
+
+	var superseded []string
+	for _, pr := range prs {
+		if !strings.EqualFold(pr.GetHead().GetRepo().GetFullName(), repo.SourceOwner+"/"+repo.SourceRepo) {
+			continue
+		}
+		oldVersion, ok := staleVersion(app, manifest, pr, version)
+		if !ok {
+			continue
+		}
+		comment := fmt.Sprintf("Superseded by %s, which rolls out %s.", url, version)
+		if err := gitbot.ClosePullRequest(ctx, client, repo.SourceOwner, repo.SourceRepo, pr, comment); err != nil {
+			slog.Error("Failed to close superseded PR", "url", pr.GetHTMLURL(), "error", err)
+			continue
+		}
+		slog.Info("Closed superseded PR", "url", pr.GetHTMLURL(), "version", oldVersion, "new_version", version)
+		superseded = append(superseded, pr.GetHTMLURL())
+	}
+	return superseded
+}
@@ -0,0 +1,51 @@
+package flow
+
+import (
+	"testing"
+
+	"github.com/google/go-github/v75/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaleVersion(t *testing.T) {
+	app := Application{Name: "app"}
+	manifest := Manifest{Env: "dev"}
+	pr := func(branch, title string) *github.PullRequest {
+		return &github.PullRequest{
+			Title: github.Ptr(title),
+			Head:  &github.PullRequestBranch{Ref: github.Ptr(branch)},
+		}
+	}
+
+	testcases := []struct {
+		name     string
+		manifest Manifest
+		pr       *github.PullRequest
+		expected string
+		ok       bool
+	}{
+		{name: "older version", manifest: manifest, pr: pr("rollout/dev-app-1.2.3-1", "Rollout dev app 1.2.3"), expected: "1.2.3", ok: true},
+		{name: "retried attempt", manifest: manifest, pr: pr("rollout/dev-app-v1.0.0-rc.1-2", "Rollout dev app v1.0.0-rc.1"), expected: "v1.0.0-rc.1", ok: true},
+		{name: "newer version", manifest: manifest, pr: pr("rollout/dev-app-1.3.0-1", "Rollout dev app 1.3.0")},
+		{name: "unorderable version", manifest: manifest, pr: pr("rollout/dev-app-latest-1", "Rollout dev app latest")},
+		{name: "same version", manifest: manifest, pr: pr("rollout/dev-app-1.2.4-1", "Rollout dev app 1.2.4")},
+		{name: "other env", manifest: manifest, pr: pr("rollout/prod-app-1.2.3-1", "Rollout prod app 1.2.3")},
+		{name: "other app", manifest: manifest, pr: pr("rollout/dev-app-worker-1.2.3-1", "Rollout dev app-worker 1.2.3")},
+		{name: "without attempt", manifest: manifest, pr: pr("rollout/dev-app-1.2.3", "Rollout dev app 1.2.3")},
+		{name: "human branch", manifest: manifest, pr: pr("feature/dev-app-1", "Fix dev app")},
+		{
+			name:     "custom branch name",
+			manifest: Manifest{Env: "dev", BranchName: "deploy/${env}/${version}", CommitMessage: "Deploy ${version} to ${env}"},
+			pr:       pr("deploy/dev/1.2.3-1", "Deploy 1.2.3 to dev"),
+			expected: "1.2.3",
+			ok:       true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			version, ok := staleVersion(app, tc.manifest, tc.pr, "1.2.4")
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.expected, version)
+		})
+	}
+}
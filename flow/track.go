@@ -0,0 +1,153 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v75/github"
+	"github.com/ubie-oss/flow/v4/history"
+	"github.com/ubie-oss/flow/v4/metrics"
+)
+
+// pullRequestChange is a change of the status of the rollout pushed to a branch of a manifest repository.
+type pullRequestChange struct {
+	owner  string
+	repo   string
+	branch string
+	status history.Status
+	// from are the statuses the change applies to
+	from []history.Status
+	// checkSuite is the app of the check suite which completed, whose suites are tracked in FailedChecks
+	checkSuite string
+}
+
+// failedConclusions are the conclusions of check suites which fail PRs.
+var failedConclusions = []string{"failure", "timed_out", "cancelled", "action_required", "startup_failure"}
+
+// IsPullRequestEvent reports whether the webhook event is one of the PRs of manifest repositories,
+// which are handled by TrackGitHubEvent instead of being rolled out.
+func IsPullRequestEvent(event interface{}) bool {
+	switch event.(type) {
+	case *github.PullRequestEvent, *github.CheckSuiteEvent:
+		return true
+	}
+	return false
+}
+
+// TrackGitHubEvent records what became of the PR of a rollout from a pull_request or check_suite webhook event
+// of the manifest repository: merged, closed or failing its checks. It returns the record updated, or nil if
+// the event is not about a branch flow pushed or does not change its status.
+func (f *Flow) TrackGitHubEvent(ctx context.Context, event interface{}) (*history.Record, error) {
+	change, ok := newPullRequestChange(event)
+	if !ok || f.history == nil {
+		return nil, nil
+	}
+
+	records, err := f.history.List(ctx, history.Filter{Owner: change.owner, Repo: change.repo, Branch: change.branch})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rollouts: %w", err)
+	}
+	// the most recent rollout pushed is the one in the PR when a single PR is updated with each version
+	for _, rec := range records {
+		if rec.Status == history.StatusSkipped {
+			continue
+		}
+		if !slices.Contains(change.from, rec.Status) {
+			return nil, nil
+		}
+		if change.checkSuite != "" && !trackCheckSuite(&rec, change) {
+			// other check suites are still failing, or the PR already failed its checks
+			if err := f.history.Put(ctx, &rec); err != nil {
+				return nil, fmt.Errorf("failed to record rollout: %w", err)
+			}
+			return nil, nil
+		}
+		rec.Status = change.status
+		if err := f.history.Put(ctx, &rec); err != nil {
+			return nil, fmt.Errorf("failed to record rollout: %w", err)
+		}
+		slog.Info("Tracked pull request", "env", rec.Env, "image", rec.Image, "version", rec.Tag, "url", rec.PullRequestURL, "status", rec.Status)
+		if rec.Status != history.StatusOpened {
+			metrics.PullRequestOutcomes.WithLabelValues(rec.Image, rec.Env, string(rec.Status)).Inc()
+			f.notifyRecord(ctx, string(rec.Status), &rec)
+		}
+		return &rec, nil
+	}
+	return nil, nil
+}
+
+// trackCheckSuite records the check suite of the change as failing or passing in the record,
+// and reports whether the status of the record changes: when the first suite fails or the last failing one passes.
+func trackCheckSuite(rec *history.Record, change pullRequestChange) bool {
+	failed := change.status == history.StatusChecksFailed
+	rec.FailedChecks = slices.DeleteFunc(slices.Clone(rec.FailedChecks), func(suite string) bool { return suite == change.checkSuite })
+	if failed {
+		rec.FailedChecks = append(rec.FailedChecks, change.checkSuite)
+	}
+	if rec.Status != history.StatusChecksFailed {
+		return true
+	}
+	return !failed && len(rec.FailedChecks) == 0
+}
+
+// newPullRequestChange returns the change of the event, or false if it does not change statuses.
+func newPullRequestChange(event interface{}) (pullRequestChange, bool) {
+	pending := []history.Status{history.StatusRunning, history.StatusOpened, history.StatusChecksFailed, history.StatusFailed}
+	switch e := event.(type) {
+	case *github.PullRequestEvent:
+		pr := e.GetPullRequest()
+		// PRs from forks are not the ones of flow
+		if !strings.EqualFold(pr.GetHead().GetRepo().GetFullName(), e.GetRepo().GetFullName()) {
+			return pullRequestChange{}, false
+		}
+		change := pullRequestChange{
+			owner:  e.GetRepo().GetOwner().GetLogin(),
+			repo:   e.GetRepo().GetName(),
+			branch: pr.GetHead().GetRef(),
+		}
+		switch {
+		case e.GetAction() == "closed" && pr.GetMerged():
+			change.status = history.StatusMerged
+			change.from = pending
+		case e.GetAction() == "closed":
+			change.status = history.StatusClosed
+			change.from = pending
+		case e.GetAction() == "reopened":
+			change.status = history.StatusOpened
+			change.from = []history.Status{history.StatusClosed}
+		default:
+			return pullRequestChange{}, false
+		}
+		return change, true
+	case *github.CheckSuiteEvent:
+		if e.GetAction() != "completed" {
+			return pullRequestChange{}, false
+		}
+		suite := e.GetCheckSuite()
+		change := pullRequestChange{
+			owner:      e.GetRepo().GetOwner().GetLogin(),
+			repo:       e.GetRepo().GetName(),
+			branch:     suite.GetHeadBranch(),
+			checkSuite: suite.GetApp().GetSlug(),
+		}
+		// each app has a single check suite for a commit, which is run again on reruns
+		if change.checkSuite == "" {
+			change.checkSuite = strconv.FormatInt(suite.GetID(), 10)
+		}
+		if slices.Contains(failedConclusions, suite.GetConclusion()) {
+			change.status = history.StatusChecksFailed
+			change.from = []history.Status{history.StatusOpened, history.StatusChecksFailed}
+		} else {
+			// checks passing when they are run again, once all the failing suites pass
+			change.status = history.StatusOpened
+			change.from = []history.Status{history.StatusChecksFailed}
+		}
+		return change, true
+	default:
+		return pullRequestChange{}, false
+	}
+}
@@ -0,0 +1,129 @@
+package flow
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v75/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/ubie-oss/flow/v4/history"
+)
+
+func pullRequestEvent(action, branch string, merged bool) *github.PullRequestEvent {
+	return &github.PullRequestEvent{
+		Action: github.Ptr(action),
+		Repo: &github.Repository{
+			Name:     github.Ptr("manifests"),
+			FullName: github.Ptr("ubie-oss/manifests"),
+			Owner:    &github.User{Login: github.Ptr("ubie-oss")},
+		},
+		PullRequest: &github.PullRequest{
+			Merged: github.Ptr(merged),
+			Head: &github.PullRequestBranch{
+				Ref:  github.Ptr(branch),
+				Repo: &github.Repository{FullName: github.Ptr("ubie-oss/manifests")},
+			},
+		},
+	}
+}
+
+func checkSuiteEvent(branch, app, conclusion string) *github.CheckSuiteEvent {
+	return &github.CheckSuiteEvent{
+		Action: github.Ptr("completed"),
+		Repo: &github.Repository{
+			Name:  github.Ptr("manifests"),
+			Owner: &github.User{Login: github.Ptr("ubie-oss")},
+		},
+		CheckSuite: &github.CheckSuite{
+			HeadBranch: github.Ptr(branch),
+			Conclusion: github.Ptr(conclusion),
+			App:        &github.App{Slug: github.Ptr(app)},
+		},
+	}
+}
+
+func TestTrackGitHubEvent(t *testing.T) {
+	ctx := context.Background()
+	f := &Flow{history: history.NewMemoryStore(10)}
+	rec := &history.Record{Image: "app-image", Tag: "v1", Env: "production", Owner: "ubie-oss", Repo: "manifests", Branch: "rollout/production-app-v1-1", Status: history.StatusOpened}
+	assert.Nil(t, f.history.Put(ctx, rec))
+
+	tests := []struct {
+		name  string
+		event interface{}
+		want  history.Status
+	}{
+		{"unknown branch", pullRequestEvent("closed", "feature", true), history.StatusOpened},
+		{"opened", pullRequestEvent("opened", rec.Branch, false), history.StatusOpened},
+		{"checks failed", checkSuiteEvent(rec.Branch, "github-actions", "failure"), history.StatusChecksFailed},
+		{"checks passed", checkSuiteEvent(rec.Branch, "github-actions", "success"), history.StatusOpened},
+		{"closed", pullRequestEvent("closed", rec.Branch, false), history.StatusClosed},
+		{"checks of a closed PR", checkSuiteEvent(rec.Branch, "github-actions", "failure"), history.StatusClosed},
+		{"reopened", pullRequestEvent("reopened", rec.Branch, false), history.StatusOpened},
+		{"merged", pullRequestEvent("closed", rec.Branch, true), history.StatusMerged},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := f.TrackGitHubEvent(ctx, tt.event)
+			assert.Nil(t, err)
+			got, err := f.history.Get(ctx, rec.ID)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got.Status)
+		})
+	}
+}
+
+func TestTrackGitHubEventWithCheckSuites(t *testing.T) {
+	ctx := context.Background()
+	f := &Flow{history: history.NewMemoryStore(10)}
+	rec := &history.Record{Image: "app-image", Tag: "v1", Env: "production", Owner: "ubie-oss", Repo: "manifests", Branch: "rollout/production-app-v1-1", Status: history.StatusOpened}
+	assert.Nil(t, f.history.Put(ctx, rec))
+
+	tests := []struct {
+		name   string
+		event  interface{}
+		want   history.Status
+		failed []string
+	}{
+		{"actions failed", checkSuiteEvent(rec.Branch, "github-actions", "failure"), history.StatusChecksFailed, []string{"github-actions"}},
+		{"circleci failed", checkSuiteEvent(rec.Branch, "circleci", "timed_out"), history.StatusChecksFailed, []string{"github-actions", "circleci"}},
+		{"circleci passed on rerun", checkSuiteEvent(rec.Branch, "circleci", "success"), history.StatusChecksFailed, []string{"github-actions"}},
+		{"another suite passed", checkSuiteEvent(rec.Branch, "codecov", "success"), history.StatusChecksFailed, []string{"github-actions"}},
+		{"actions passed on rerun", checkSuiteEvent(rec.Branch, "github-actions", "success"), history.StatusOpened, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := f.TrackGitHubEvent(ctx, tt.event)
+			assert.Nil(t, err)
+			got, err := f.history.Get(ctx, rec.ID)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got.Status)
+			assert.ElementsMatch(t, tt.failed, got.FailedChecks)
+		})
+	}
+}
+
+func TestTrackGitHubEventWithSinglePR(t *testing.T) {
+	ctx := context.Background()
+	f := &Flow{history: history.NewMemoryStore(10)}
+	branch := "rollout/production-app"
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []*history.Record{
+		{Tag: "v1", Owner: "ubie-oss", Repo: "manifests", Branch: branch, Status: history.StatusOpened, CreatedAt: created},
+		{Tag: "v2", Owner: "ubie-oss", Repo: "manifests", Branch: branch, Status: history.StatusOpened, CreatedAt: created.Add(time.Minute)},
+		{Tag: "v2", Owner: "ubie-oss", Repo: "manifests", Branch: branch, Status: history.StatusSkipped, CreatedAt: created.Add(2 * time.Minute)},
+	}
+	for _, rec := range records {
+		assert.Nil(t, f.history.Put(ctx, rec))
+	}
+
+	// the PR has the most recent version pushed to the branch
+	rec, err := f.TrackGitHubEvent(ctx, pullRequestEvent("closed", branch, true))
+	assert.Nil(t, err)
+	assert.Equal(t, records[1].ID, rec.ID)
+	assert.Equal(t, history.StatusMerged, rec.Status)
+
+	old, _ := f.history.Get(ctx, records[0].ID)
+	assert.Equal(t, history.StatusOpened, old.Status)
+}
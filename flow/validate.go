@@ -0,0 +1,322 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/dlclark/regexp2"
+	"github.com/ubie-oss/flow/v4/gitbot"
+	"github.com/ubie-oss/flow/v4/notify"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigProblem is a problem found in a config file.
+type ConfigProblem struct {
+	Line    int
+	Message string
+}
+
+// ConfigError lists all problems found in a config file.
+type ConfigError struct {
+	Problems []ConfigProblem
+}
+
+func (e *ConfigError) Error() string {
+	messages := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		messages = append(messages, fmt.Sprintf("line %d: %s", p.Line, p.Message))
+	}
+	return fmt.Sprintf("%d problem(s) in the config:\n%s", len(e.Problems), strings.Join(messages, "\n"))
+}
+
+// LoadConfig decodes the config file rejecting unknown keys and validates it.
+// All problems are returned at once as a *ConfigError with their line numbers.
+func LoadConfig(data []byte) (*Config, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 {
+		return nil, errors.New("the config is empty")
+	}
+
+	v := &validator{}
+	v.checkKnownFields(&root, reflect.TypeOf(Config{}))
+
+	c := new(Config)
+	if err := root.Decode(c); err != nil {
+		return nil, err
+	}
+	v.validate(c, root.Content[0])
+
+	if len(v.problems) > 0 {
+		sort.SliceStable(v.problems, func(i, j int) bool { return v.problems[i].Line < v.problems[j].Line })
+		return nil, &ConfigError{Problems: v.problems}
+	}
+	return c, nil
+}
+
+type validator struct {
+	problems []ConfigProblem
+}
+
+func (v *validator) addf(node *yaml.Node, format string, args ...interface{}) {
+	v.problems = append(v.problems, ConfigProblem{Line: node.Line, Message: fmt.Sprintf(format, args...)})
+}
+
+// checkKnownFields reports keys that do not map to any field of t.
+func (v *validator) checkKnownFields(node *yaml.Node, t reflect.Type) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			v.checkKnownFields(child, t)
+		}
+	case yaml.MappingNode:
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := yamlFields(t)
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key := node.Content[i]
+				field, ok := fields[key.Value]
+				if !ok {
+					v.addf(key, "unknown key %q", key.Value)
+					continue
+				}
+				v.checkKnownFields(node.Content[i+1], field)
+			}
+		case reflect.Map:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				v.checkKnownFields(node.Content[i+1], t.Elem())
+			}
+		}
+	case yaml.SequenceNode:
+		if t.Kind() == reflect.Slice {
+			for _, child := range node.Content {
+				v.checkKnownFields(child, t.Elem())
+			}
+		}
+	}
+}
+
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// child returns the node at the key or index in node, or node itself if there is none,
+// so that problems are reported at the closest line.
+func child(node *yaml.Node, keys ...interface{}) *yaml.Node {
+	for _, key := range keys {
+		var next *yaml.Node
+		switch k := key.(type) {
+		case string:
+			if node.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(node.Content); i += 2 {
+					if node.Content[i].Value == k {
+						next = node.Content[i+1]
+					}
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && k < len(node.Content) {
+				next = node.Content[k]
+			}
+		}
+		if next == nil {
+			return node
+		}
+		node = next
+	}
+	return node
+}
+
+func (v *validator) validate(c *Config, root *yaml.Node) {
+	images := map[string]int{}
+	for i, app := range c.ApplicationList {
+		appNode := child(root, "applications", i)
+
+		if app.Image == "" {
+			v.addf(appNode, "image is required")
+		} else if line, ok := images[app.Image]; ok {
+			v.addf(child(appNode, "image"), "image %s is already used at line %d", app.Image, line)
+		} else {
+			images[app.Image] = child(appNode, "image").Line
+			if _, err := regexp2.Compile(fmt.Sprintf(imageRewriteRegexTemplate, app.Image), 0); err != nil {
+				v.addf(child(appNode, "image"), "image %s cannot be used in a regular expression: %s", app.Image, err)
+			}
+		}
+		if app.Name == "" && app.SourceName == "" {
+			v.addf(appNode, "either name or source_name is required")
+		}
+		for j, key := range app.AdditionalRewriteKeys {
+			if _, err := regexp2.Compile(fmt.Sprintf(additionalRewriteKeysRegexTemplate, key), 0); err != nil {
+				v.addf(child(appNode, "additional_rewrite_keys", j), "invalid additional rewrite key %q: %s", key, err)
+			}
+		}
+		for j, prefix := range app.AdditionalRewritePrefix {
+			if _, err := regexp2.Compile(fmt.Sprintf(additionalRewritePrefixRegexTemplate, prefix), 0); err != nil {
+				v.addf(child(appNode, "additional_rewrite_prefix", j), "invalid additional rewrite prefix %q: %s", prefix, err)
+			}
+		}
+		if len(app.Manifests) == 0 {
+			v.addf(appNode, "manifests are required")
+		}
+
+		for j, manifest := range app.Manifests {
+			v.validateManifest(c, app, manifest, child(appNode, "manifests", j))
+		}
+		v.validateNotifications(app.Notifications, child(appNode, "notifications"))
+	}
+}
+
+func (v *validator) validateManifest(c *Config, app Application, m Manifest, node *yaml.Node) {
+	if m.Env == "" {
+		v.addf(node, "env is required")
+	}
+
+	owner, name := m.ManifestOwner, m.ManifestName
+	if owner == "" {
+		owner = app.ManifestOwner
+	}
+	if owner == "" {
+		owner = c.DefaultManifestOwner
+	}
+	if name == "" {
+		name = app.ManifestName
+	}
+	if name == "" {
+		name = c.DefaultManifestName
+	}
+	if owner == "" {
+		v.addf(node, "manifest_owner is required unless it is set in the application or default_manifest_owner")
+	}
+	if name == "" {
+		v.addf(node, "manifest_name is required unless it is set in the application or default_manifest_name")
+	}
+
+	if len(m.Files) == 0 {
+		v.addf(node, "files are required")
+	}
+	for i, file := range m.Files {
+		fileNode := child(node, "files", i)
+		if file.Path == "" {
+			v.addf(fileNode, "path is required")
+		}
+		switch file.Mode {
+		case "", FileModeRegex, FileModeKustomize, FileModeHelm:
+			if len(file.YAMLPaths) > 0 {
+				v.addf(fileNode, "yaml_paths can only be used with the yaml mode")
+			}
+		case FileModeYAML:
+			if len(file.YAMLPaths) == 0 {
+				v.addf(fileNode, "yaml_paths are required in the yaml mode")
+			}
+			for j, p := range file.YAMLPaths {
+				if err := gitbot.ValidateYAMLPath(p); err != nil {
+					v.addf(child(fileNode, "yaml_paths", j), "%s", err)
+				}
+			}
+		default:
+			v.addf(child(fileNode, "mode"), "unknown mode %q", file.Mode)
+		}
+	}
+
+	filters := child(node, "filters")
+	if m.Filters.Semver != "" {
+		if _, err := semver.NewConstraint(m.Filters.Semver); err != nil {
+			v.addf(child(filters, "semver"), "invalid semver constraint %q: %s", m.Filters.Semver, err)
+		}
+	}
+	for key, patterns := range map[string][]string{"include_patterns": m.Filters.IncludePatterns, "exclude_patterns": m.Filters.ExcludePatterns} {
+		for i, pattern := range patterns {
+			if _, err := compilePattern(pattern); err != nil {
+				v.addf(child(filters, key, i), "invalid pattern %q: %s", pattern, err)
+			}
+		}
+	}
+	for key, globs := range map[string][]string{"include_globs": m.Filters.IncludeGlobs, "exclude_globs": m.Filters.ExcludeGlobs} {
+		for i, glob := range globs {
+			if _, err := path.Match(glob, ""); err != nil {
+				v.addf(child(filters, key, i), "invalid glob %q: %s", glob, err)
+			}
+		}
+	}
+
+	switch m.VersionOrdering {
+	case "", VersionOrderingSemver, VersionOrderingTimestamp:
+	default:
+		v.addf(child(node, "version_ordering"), "unknown version ordering %q", m.VersionOrdering)
+	}
+	if m.DowngradeLabel != "" && !m.ForbidDowngrade {
+		v.addf(child(node, "downgrade_label"), "downgrade_label requires forbid_downgrade")
+	}
+	if m.SupersedePRs && m.CommitWithoutPR {
+		v.addf(child(node, "supersede_prs"), "supersede_prs cannot be used with commit_without_pr")
+	}
+	switch m.PRStrategy {
+	case "", PRStrategyNew:
+	case PRStrategySingle:
+		if m.CommitWithoutPR {
+			v.addf(child(node, "pr_strategy"), "pr_strategy single cannot be used with commit_without_pr")
+		}
+		if m.SupersedePRs {
+			v.addf(child(node, "supersede_prs"), "supersede_prs cannot be used with pr_strategy single")
+		}
+		if strings.Contains(m.BranchName, "${version}") {
+			v.addf(child(node, "branch_name"), "branch_name must not contain ${version} with pr_strategy single")
+		}
+	default:
+		v.addf(child(node, "pr_strategy"), "unknown pr strategy %q", m.PRStrategy)
+	}
+	v.validateNotifications(m.Notifications, child(node, "notifications"))
+}
+
+func (v *validator) validateNotifications(notifications []Notification, node *yaml.Node) {
+	for i, n := range notifications {
+		nNode := child(node, i)
+		switch n.Type {
+		case notify.TypeSlack:
+			if n.SecretEnv != "" {
+				v.addf(child(nNode, "secret_env"), "secret_env can only be used with the webhook type")
+			}
+		case notify.TypeWebhook:
+		case "":
+			v.addf(nNode, "type is required")
+		default:
+			v.addf(child(nNode, "type"), "unknown notification type %q", n.Type)
+		}
+		if (n.URL == "") == (n.URLEnv == "") {
+			v.addf(nNode, "either url or url_env is required")
+		}
+		for j, event := range n.Events {
+			if !slices.Contains(notify.EventTypes, event) {
+				v.addf(child(nNode, "events", j), "unknown notification event %q", event)
+			}
+		}
+		if n.Template != "" {
+			if _, err := notify.ParseTemplate(n.Template); err != nil {
+				v.addf(child(nNode, "template"), "invalid template: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package flow
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	data, err := os.ReadFile("../config-example.yaml")
+	assert.Nil(t, err)
+
+	c, err := LoadConfig(data)
+	assert.Nil(t, err)
+	assert.Equal(t, "gcr.io/$PROJECT_ID/foo", c.ApplicationList[0].Image)
+	assert.Equal(t, "dev", c.ApplicationList[0].Manifests[0].BaseBranch)
+}
+
+func TestLoadConfigProblems(t *testing.T) {
+	_, err := LoadConfig([]byte(`applications:
+  - image: gcr.io/foo/bar
+    source_name: bar
+    manifests:
+      - env: dev
+        branch: dev
+        files:
+          - overlays/dev/deployment.yaml
+      - env: qa
+        files:
+          - path: overlays/qa/deployment.yaml
+            mode: yaml
+        pr_strategy: multiple
+        filters:
+          semver: ">>1"
+          include_patterns:
+            - "("
+  - image: gcr.io/foo/bar
+    source_name: bar
+    manifest_owner: foo
+    manifests:
+      - env: prod
+        version_ordering: date
+        supersede_prs: true
+        commit_without_pr: true
+        notifications:
+          - type: slack
+            secret_env: SECRET
+            events: [opened]
+          - type: teams
+            url: https://example.com
+            template: "{{.Image"
+  - image: gcr.io/foo/baz
+    source_name: baz
+    manifest_owner: foo
+    manifests:
+      - env: prod
+        files:
+          - deployment.yaml
+        pr_strategy: single
+        supersede_prs: true
+default_manifest_name: manifests
+gitauthor:
+  name: flow
+`))
+	var configErr *ConfigError
+	assert.True(t, errors.As(err, &configErr))
+	assert.Equal(t, []ConfigProblem{
+		{Line: 5, Message: "manifest_owner is required unless it is set in the application or default_manifest_owner"},
+		{Line: 6, Message: `unknown key "branch"`},
+		{Line: 9, Message: "manifest_owner is required unless it is set in the application or default_manifest_owner"},
+		{Line: 11, Message: "yaml_paths are required in the yaml mode"},
+		{Line: 13, Message: `unknown pr strategy "multiple"`},
+		{Line: 15, Message: `invalid semver constraint ">>1": improper constraint: ">>1"`},
+		{Line: 17, Message: `invalid pattern "(": error parsing regexp: missing closing ) in ` + "`(`"},
+		{Line: 18, Message: "image gcr.io/foo/bar is already used at line 2"},
+		{Line: 22, Message: "files are required"},
+		{Line: 23, Message: `unknown version ordering "date"`},
+		{Line: 24, Message: "supersede_prs cannot be used with commit_without_pr"},
+		{Line: 27, Message: "either url or url_env is required"},
+		{Line: 28, Message: "secret_env can only be used with the webhook type"},
+		{Line: 29, Message: `unknown notification event "opened"`},
+		{Line: 30, Message: `unknown notification type "teams"`},
+		{Line: 32, Message: `invalid template: template: message:1: unclosed action`},
+		{Line: 41, Message: "supersede_prs cannot be used with pr_strategy single"},
+		{Line: 43, Message: `unknown key "gitauthor"`},
+	}, configErr.Problems)
+}
@@ -7,6 +7,8 @@ import (
 
 	"github.com/bradleyfalzon/ghinstallation/v2"
 	"github.com/google/go-github/v75/github"
+	"github.com/ubie-oss/flow/v4/metrics"
+	"github.com/ubie-oss/flow/v4/tracing"
 
 	"golang.org/x/oauth2"
 )
@@ -14,11 +16,12 @@ import (
 func NewGitHubClient(ctx context.Context, token string) *github.Client {
 	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
 	tc := oauth2.NewClient(ctx, ts)
+	tc.Transport = tracing.Transport(metrics.GitHubTransport(tc.Transport))
 	return github.NewClient(tc)
 }
 
 func NewGitHubClientWithApp(ctx context.Context, appID, installationID int64, privateKey string) (*github.Client, error) {
-	tr := http.DefaultTransport
+	tr := tracing.Transport(metrics.GitHubTransport(http.DefaultTransport))
 	itr, err := ghinstallation.New(tr, appID, installationID, []byte(privateKey))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create GitHub installation transport: %w", err)
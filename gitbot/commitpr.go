@@ -2,14 +2,22 @@ package gitbot
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/dlclark/regexp2"
 	"github.com/google/go-github/v75/github"
+	"github.com/pmezard/go-difflib/difflib"
 )
 
 func (r *release) getRef(ctx context.Context, client *github.Client) (ref *github.Reference, err error) {
+	if r.repo.ForceUpdate {
+		return r.getResetRef(ctx, client)
+	}
 	if ref, _, err = client.Git.GetRef(ctx, r.repo.SourceOwner, r.repo.SourceRepo, "refs/heads/"+r.repo.CommitBranch); err == nil {
 		return ref, nil
 	}
@@ -23,24 +31,115 @@ func (r *release) getRef(ctx context.Context, client *github.Client) (ref *githu
 	return ref, err
 }
 
+// getResetRef returns the commit branch pointing to the head of the base branch, creating the branch if it does not exist.
+// The branch is updated only when the commit is pushed.
+func (r *release) getResetRef(ctx context.Context, client *github.Client) (*github.Reference, error) {
+	baseRef, _, err := client.Git.GetRef(ctx, r.repo.SourceOwner, r.repo.SourceRepo, "refs/heads/"+r.repo.BaseBranch)
+	if err != nil {
+		return nil, err
+	}
+	ref, res, err := client.Git.GetRef(ctx, r.repo.SourceOwner, r.repo.SourceRepo, "refs/heads/"+r.repo.CommitBranch)
+	if err != nil {
+		if res == nil || res.StatusCode != http.StatusNotFound {
+			return nil, err
+		}
+		newRef := github.CreateRef{Ref: "refs/heads/" + r.repo.CommitBranch, SHA: *baseRef.Object.SHA}
+		ref, _, err = client.Git.CreateRef(ctx, r.repo.SourceOwner, r.repo.SourceRepo, newRef)
+		return ref, err
+	}
+	ref.Object.SHA = baseRef.Object.SHA
+	return ref, nil
+}
+
 func (r *release) makeChange(ctx context.Context, client *github.Client, filePath, regexText string, evaluator regexp2.MatchEvaluator) {
-	// rewrite if target is already changed
-	content, ok := r.changedContentMap[filePath]
-	if ok {
-		r.changedContentMap[filePath] = getChangedText(content, regexText, evaluator)
+	// skip the file if an earlier change of it failed
+	if _, ok := r.errs[filePath]; ok {
+		return
+	}
+	content, err := r.getContent(ctx, client, filePath)
+	if err != nil {
+		slog.Error("Error fetching content", "error", err)
+		r.errs[filePath] = fmt.Errorf("failed to fetch %s: %w", filePath, err)
 		return
 	}
 
-	content, err := r.getOriginalContent(ctx, client, filePath, r.repo.BaseBranch)
+	r.changedContentMap[filePath] = getChangedText(content, regexText, evaluator)
+}
+
+func (r *release) makeYAMLChange(ctx context.Context, client *github.Client, filePath string, paths []string, evaluator ValueEvaluator) {
+	r.rewrite(ctx, client, filePath, func(content string) (string, error) {
+		return getChangedYAML(content, paths, evaluator)
+	})
+}
+
+func (r *release) makeKustomizeChange(ctx context.Context, client *github.Client, filePath, image string, tagEvaluator, digestEvaluator ValueEvaluator) {
+	r.rewrite(ctx, client, filePath, func(content string) (string, error) {
+		return getChangedKustomization(content, image, tagEvaluator, digestEvaluator)
+	})
+}
+
+func (r *release) makeHelmChange(ctx context.Context, client *github.Client, filePath, image string, tagEvaluator, digestEvaluator ValueEvaluator) {
+	r.rewrite(ctx, client, filePath, func(content string) (string, error) {
+		return getChangedHelmValues(content, image, tagEvaluator, digestEvaluator)
+	})
+}
+
+// rewrite changes the content of the file with the rewriter and keeps it unchanged on errors.
+func (r *release) rewrite(ctx context.Context, client *github.Client, filePath string, rewriter func(string) (string, error)) {
+	// skip the file if an earlier change of it failed
+	if _, ok := r.errs[filePath]; ok {
+		return
+	}
+	content, err := r.getContent(ctx, client, filePath)
 	if err != nil {
 		slog.Error("Error fetching content", "error", err)
+		r.errs[filePath] = fmt.Errorf("failed to fetch %s: %w", filePath, err)
 		return
 	}
 
-	changed := getChangedText(content, regexText, evaluator)
+	changed, err := rewriter(content)
+	if err != nil {
+		slog.Error("Error rewriting file", "file", filePath, "error", err)
+		r.errs[filePath] = fmt.Errorf("failed to rewrite %s: %w", filePath, err)
+		return
+	}
 	r.changedContentMap[filePath] = changed
 }
 
+// getContent returns the content already changed in this release, or the original one in the base branch.
+func (r *release) getContent(ctx context.Context, client *github.Client, filePath string) (string, error) {
+	if content, ok := r.changedContentMap[filePath]; ok {
+		return content, nil
+	}
+	content, err := r.getOriginalContent(ctx, client, filePath, r.repo.BaseBranch)
+	if err != nil {
+		return "", err
+	}
+	r.originalContentMap[filePath] = content
+	return content, nil
+}
+
+func (r *release) diffs() map[string]string {
+	diffs := map[string]string{}
+	for path, changed := range r.changedContentMap {
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        splitLines(r.originalContentMap[path]),
+			B:        splitLines(changed),
+			FromFile: "a/" + path,
+			ToFile:   "b/" + path,
+			Context:  3,
+		})
+		if err != nil {
+			slog.Error("Error rendering diff", "file", path, "error", err)
+			continue
+		}
+		if diff != "" {
+			diffs[path] = diff
+		}
+	}
+	return diffs
+}
+
 func (r *release) getTree(ctx context.Context, client *github.Client, ref *github.Reference) (*github.Tree, error) {
 	entries := []*github.TreeEntry{}
 	for path, content := range r.changedContentMap {
@@ -68,7 +167,8 @@ func (r *release) pushCommit(ctx context.Context, client *github.Client, ref *gi
 	}
 
 	ref.Object.SHA = newCommit.SHA
-	updateRef := github.UpdateRef{SHA: *newCommit.SHA, Force: github.Ptr(false)}
+	r.commitSHA = newCommit.GetSHA()
+	updateRef := github.UpdateRef{SHA: *newCommit.SHA, Force: github.Ptr(r.repo.ForceUpdate)}
 	_, _, err = client.Git.UpdateRef(ctx, r.repo.SourceOwner, r.repo.SourceRepo, *ref.Ref, updateRef)
 	return err
 }
@@ -95,6 +195,44 @@ func (r *release) createPR(ctx context.Context, client *github.Client) (*string,
 	return github.Ptr(pr.GetHTMLURL()), nil
 }
 
+// updatePR rewrites the title, body and labels of the open PR from the commit branch, or creates one if there is none.
+func (r *release) updatePR(ctx context.Context, client *github.Client) (*string, error) {
+	prs, _, err := client.PullRequests.List(ctx, r.repo.SourceOwner, r.repo.SourceRepo, &github.PullRequestListOptions{
+		State: "open",
+		Head:  r.repo.SourceOwner + ":" + r.repo.CommitBranch,
+		Base:  r.repo.BaseBranch,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(prs) == 0 {
+		return r.createPR(ctx, client)
+	}
+
+	pr, _, err := client.PullRequests.Edit(ctx, r.repo.SourceOwner, r.repo.SourceRepo, prs[0].GetNumber(), &github.PullRequest{
+		Title: github.Ptr(r.message),
+		Body:  github.Ptr(r.body),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// labels added by humans are kept
+	if err := r.addLabels(ctx, client, pr.GetNumber()); err != nil {
+		slog.Error("Error adding labels", "error", err)
+	}
+	for _, label := range r.staleLabels {
+		if slices.Contains(r.labels, label) || !HasLabel(prs[0], label) {
+			continue
+		}
+		if _, err := client.Issues.RemoveLabelForIssue(ctx, r.repo.SourceOwner, r.repo.SourceRepo, pr.GetNumber(), label); err != nil {
+			slog.Error("Error removing label", "label", label, "error", err)
+		}
+	}
+
+	return github.Ptr(pr.GetHTMLURL()), nil
+}
+
 func (r *release) addLabels(ctx context.Context, client *github.Client, prNumber int) error {
 	_, _, err := client.Issues.AddLabelsToIssue(ctx, r.repo.SourceOwner, r.repo.SourceRepo, prNumber, r.labels)
 	return err
@@ -114,6 +252,15 @@ func (r *release) getOriginalContent(ctx context.Context, client *github.Client,
 	return f.GetContent()
 }
 
+// splitLines splits text into lines keeping their line breaks.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
 func getChangedText(original, regex string, evaluator regexp2.MatchEvaluator) string {
 	re := regexp2.MustCompile(regex, 0)
 	result, err := re.ReplaceFunc(original, evaluator, 0, -1)
@@ -0,0 +1,63 @@
+package gitbot
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/v75/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffs(t *testing.T) {
+	r := NewRelease(Repo{}, Author{}, "", "", nil).(*release)
+	r.originalContentMap["deployment.yaml"] = "kind: Deployment\nimage: gcr.io/foo/bar:old\n"
+	r.changedContentMap["deployment.yaml"] = "kind: Deployment\nimage: gcr.io/foo/bar:new\n"
+	r.originalContentMap["service.yaml"] = "kind: Service\n"
+	r.changedContentMap["service.yaml"] = "kind: Service\n"
+
+	assert.Equal(t, map[string]string{
+		"deployment.yaml": `--- a/deployment.yaml
++++ b/deployment.yaml
+@@ -1,2 +1,2 @@
+ kind: Deployment
+-image: gcr.io/foo/bar:old
++image: gcr.io/foo/bar:new
+`,
+	}, r.Diffs())
+}
+
+func TestUpdatePRLabels(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method + " " + r.URL.Path {
+		case "GET /repos/ubie-oss/manifests/pulls":
+			_, _ = w.Write([]byte(`[{"number": 1, "labels": [{"name": "app"}, {"name": "rollback"}, {"name": "do-not-merge"}]}]`))
+			return
+		case "PATCH /repos/ubie-oss/manifests/pulls/1":
+			_, _ = w.Write([]byte(`{"number": 1, "html_url": "https://github.com/ubie-oss/manifests/pull/1"}`))
+			return
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	r := NewRelease(Repo{SourceOwner: "ubie-oss", SourceRepo: "manifests", BaseBranch: "main", CommitBranch: "rollout/production-app"}, Author{}, "Rollout", "", []string{"app", "production"}).(*release)
+	r.SetStaleLabels([]string{"rollback"})
+	prURL, err := r.updatePR(context.Background(), client)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://github.com/ubie-oss/manifests/pull/1", *prURL)
+	// the labels added by humans are kept
+	assert.Equal(t, []string{
+		"POST /repos/ubie-oss/manifests/issues/1/labels [\"app\",\"production\"]\n",
+		"DELETE /repos/ubie-oss/manifests/issues/1/labels/rollback ",
+	}, requests)
+}
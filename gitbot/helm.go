@@ -0,0 +1,63 @@
+package gitbot
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// getChangedHelmValues rewrites the tag of every image block in a Helm values.yaml whose repository is the image,
+// either as repository alone or as registry/repository. Other tag keys in the file are left untouched.
+// If digestEvaluator is not nil, the digest key of the block is rewritten too, otherwise it is removed
+// so that a stale digest does not pin the old image.
+func getChangedHelmValues(original, image string, tagEvaluator, digestEvaluator ValueEvaluator) (string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(original), &doc); err != nil {
+		return original, err
+	}
+	offsets := lineOffsets(original)
+
+	var blocks []*yaml.Node
+	findHelmImageBlocks(&doc, image, &blocks)
+	if len(blocks) == 0 {
+		return original, fmt.Errorf("no image block with repository %s", image)
+	}
+
+	keys := []string{"tag"}
+	evaluators := []ValueEvaluator{tagEvaluator}
+	if digestEvaluator != nil {
+		keys = append(keys, "digest")
+		evaluators = append(evaluators, digestEvaluator)
+	}
+	edits := map[int]yamlEdit{}
+	for _, block := range blocks {
+		if err := setMappingValues(original, offsets, block, keys, evaluators, edits); err != nil {
+			return original, err
+		}
+		if digestEvaluator == nil {
+			if edit, ok := removeMappingKey(original, offsets, block, "digest"); ok {
+				edits[edit.offset] = edit
+			}
+		}
+	}
+
+	return applyEdits(original, edits), nil
+}
+
+func findHelmImageBlocks(node *yaml.Node, image string, blocks *[]*yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		if repository := mappingValue(node, "repository"); repository != nil && repository.Kind == yaml.ScalarNode {
+			name := repository.Value
+			if registry := mappingValue(node, "registry"); registry != nil && registry.Kind == yaml.ScalarNode && registry.Value != "" {
+				name = registry.Value + "/" + name
+			}
+			if name == image || repository.Value == image {
+				*blocks = append(*blocks, node)
+				return
+			}
+		}
+	}
+	for _, child := range node.Content {
+		findHelmImageBlocks(child, image, blocks)
+	}
+}
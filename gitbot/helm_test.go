@@ -0,0 +1,118 @@
+package gitbot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetChangedHelmValues(t *testing.T) {
+	const image = "gcr.io/foo/app"
+	tagEvaluator := func(string) string { return "new" }
+	digestEvaluator := func(string) string { return "sha256:new" }
+
+	original := `image:
+  repository: gcr.io/foo/app
+  tag: "old" # set by flow
+  pullPolicy: IfNotPresent
+sidecar:
+  image:
+    repository: gcr.io/foo/sidecar
+    tag: old
+worker:
+  image:
+    registry: gcr.io
+    repository: foo/app
+    tag: old
+`
+	expected := `image:
+  repository: gcr.io/foo/app
+  tag: "new" # set by flow
+  pullPolicy: IfNotPresent
+sidecar:
+  image:
+    repository: gcr.io/foo/sidecar
+    tag: old
+worker:
+  image:
+    registry: gcr.io
+    repository: foo/app
+    tag: new
+`
+	result, err := getChangedHelmValues(original, image, tagEvaluator, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, result)
+
+	original = `image:
+  repository: gcr.io/foo/app
+  digest: sha256:old
+replicas: 1
+`
+	expected = `image:
+  repository: gcr.io/foo/app
+  digest: sha256:new
+  tag: new
+replicas: 1
+`
+	result, err = getChangedHelmValues(original, image, tagEvaluator, digestEvaluator)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, result)
+
+	original = `image:
+  repository: gcr.io/foo/app
+`
+	expected = `image:
+  repository: gcr.io/foo/app
+  tag: new
+  digest: sha256:new
+`
+	result, err = getChangedHelmValues(original, image, tagEvaluator, digestEvaluator)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, result)
+
+	original = `image:
+  repository: gcr.io/foo/app
+  tag: old
+  digest: sha256:old
+  pullPolicy: IfNotPresent
+worker:
+  image:
+    digest: sha256:old
+    repository: gcr.io/foo/app
+`
+	expected = `image:
+  repository: gcr.io/foo/app
+  tag: new
+  pullPolicy: IfNotPresent
+worker:
+  image:
+    repository: gcr.io/foo/app
+    tag: new
+`
+	result, err = getChangedHelmValues(original, image, tagEvaluator, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, result)
+
+	// versions that would be read as numbers are quoted
+	original = `image:
+  repository: gcr.io/foo/app
+  tag: old
+worker:
+  image:
+    repository: gcr.io/foo/app
+`
+	expected = `image:
+  repository: gcr.io/foo/app
+  tag: "1.10"
+worker:
+  image:
+    repository: gcr.io/foo/app
+    tag: "1.10"
+`
+	result, err = getChangedHelmValues(original, image, func(string) string { return "1.10" }, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, result)
+
+	_, err = getChangedHelmValues("image:\n  repository: gcr.io/foo/other\n", image, tagEvaluator, nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,176 @@
+package gitbot
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// getChangedKustomization rewrites newTag of the entry for image in the images list of a kustomization.yaml.
+// An entry matches when its name or newName is the image. The entry, or the images list itself,
+// is appended if it does not exist yet. The evaluators get the current value, empty if there is none.
+// If digestEvaluator is not nil, the digest of the entry is rewritten too, otherwise it is removed
+// as kustomize would keep deploying the image of the digest instead of the new tag.
+func getChangedKustomization(original, image string, evaluator, digestEvaluator ValueEvaluator) (string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(original), &doc); err != nil {
+		return original, err
+	}
+	offsets := lineOffsets(original)
+
+	newEntry := func(indent string) string {
+		entry := fmt.Sprintf("%s- name: %s\n%s  newTag: %s\n", indent, image, indent, plainScalar(evaluator("")))
+		if digestEvaluator != nil {
+			entry += fmt.Sprintf("%s  digest: %s\n", indent, plainScalar(digestEvaluator("")))
+		}
+		return entry
+	}
+
+	if len(doc.Content) == 0 {
+		return original + "images:\n" + newEntry(""), nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return original, fmt.Errorf("line %d: kustomization is not a mapping", root.Line)
+	}
+
+	images := mappingValue(root, "images")
+	if images == nil {
+		edit := insertAfter(original, offsets, lastLine(root), "images:\n"+newEntry(""))
+		return applyEdits(original, map[int]yamlEdit{edit.offset: edit}), nil
+	}
+	if images.Kind == yaml.SequenceNode && images.Style&yaml.FlowStyle != 0 && len(images.Content) == 0 {
+		// replace "images: []" with a block sequence of the entry
+		key := mappingKey(root, "images")
+		indent := strings.Repeat(" ", key.Column-1)
+		edit := yamlEdit{offset: offsets[key.Line-1], text: indent + "images:\n" + newEntry(indent)}
+		edit.length = insertAfter(original, offsets, images.Line, "").offset - edit.offset
+		return applyEdits(original, map[int]yamlEdit{edit.offset: edit}), nil
+	}
+	if images.Kind != yaml.SequenceNode || (images.Style&yaml.FlowStyle != 0) {
+		return original, fmt.Errorf("line %d: images must be a block sequence", images.Line)
+	}
+
+	keys := []string{"newTag"}
+	evaluators := []ValueEvaluator{evaluator}
+	if digestEvaluator != nil {
+		keys = append(keys, "digest")
+		evaluators = append(evaluators, digestEvaluator)
+	}
+	edits := map[int]yamlEdit{}
+	for _, entry := range images.Content {
+		if entry.Kind != yaml.MappingNode {
+			continue
+		}
+		if !hasYAMLField(entry, "name", image) && !hasYAMLField(entry, "newName", image) {
+			continue
+		}
+		if err := setMappingValues(original, offsets, entry, keys, evaluators, edits); err != nil {
+			return original, err
+		}
+		if digestEvaluator == nil {
+			if edit, ok := removeMappingKey(original, offsets, entry, "digest"); ok {
+				edits[edit.offset] = edit
+			}
+		}
+	}
+
+	if len(edits) == 0 {
+		// items are written as "- name: ..." so the dash is two columns before the mapping
+		indent := ""
+		if len(images.Content) > 0 && images.Content[0].Column > 2 {
+			indent = strings.Repeat(" ", images.Content[0].Column-3)
+		}
+		edit := insertAfter(original, offsets, lastLine(images), newEntry(indent))
+		edits[edit.offset] = edit
+	}
+
+	return applyEdits(original, edits), nil
+}
+
+// setMappingValues rewrites the values of keys in the mapping with the evaluators,
+// appending the keys missing in the mapping.
+func setMappingValues(original string, offsets []int, mapping *yaml.Node, keys []string, evaluators []ValueEvaluator, edits map[int]yamlEdit) error {
+	for i, key := range keys {
+		if value := mappingValue(mapping, key); value != nil {
+			edit, err := scalarEdit(original, offsets, value, evaluators[i](value.Value))
+			if err != nil {
+				return err
+			}
+			edits[edit.offset] = edit
+			continue
+		}
+		indent := strings.Repeat(" ", mapping.Column-1)
+		edit := insertAfter(original, offsets, lastLine(mapping), fmt.Sprintf("%s%s: %s\n", indent, key, plainScalar(evaluators[i](""))))
+		// insertions at the same place are concatenated
+		if prev, ok := edits[edit.offset]; ok && prev.length == 0 {
+			edit.text = prev.text + edit.text
+		}
+		edits[edit.offset] = edit
+	}
+	return nil
+}
+
+// removeMappingKey returns the edit that removes the key and its value from the block mapping,
+// or false if the mapping does not have the key.
+func removeMappingKey(original string, offsets []int, mapping *yaml.Node, key string) (yamlEdit, bool) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		k, v := mapping.Content[i], mapping.Content[i+1]
+		if k.Value != key {
+			continue
+		}
+		if i == 0 {
+			// the first key shares its line with the dash of the item, so the next key takes its place
+			if len(mapping.Content) < 4 {
+				return yamlEdit{}, false
+			}
+			next := mapping.Content[2]
+			start := columnOffset(original, offsets, k.Line, k.Column)
+			return yamlEdit{offset: start, length: columnOffset(original, offsets, next.Line, next.Column) - start}, true
+		}
+		start := offsets[k.Line-1]
+		return yamlEdit{offset: start, length: insertAfter(original, offsets, lastLine(v), "").offset - start}, true
+	}
+	return yamlEdit{}, false
+}
+
+func mappingKey(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i]
+		}
+	}
+	return nil
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return resolveAlias(node.Content[i+1])
+		}
+	}
+	return nil
+}
+
+// lastLine returns the last line occupied by the node and its children.
+func lastLine(node *yaml.Node) int {
+	line := node.Line
+	for _, child := range node.Content {
+		if l := lastLine(child); l > line {
+			line = l
+		}
+	}
+	return line
+}
+
+// insertAfter returns the edit that inserts text at the beginning of the line next to line.
+func insertAfter(original string, offsets []int, line int, text string) yamlEdit {
+	if line < len(offsets) {
+		return yamlEdit{offset: offsets[line], text: text}
+	}
+	if !strings.HasSuffix(original, "\n") {
+		text = "\n" + text
+	}
+	return yamlEdit{offset: len(original), text: text}
+}
@@ -0,0 +1,166 @@
+package gitbot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetChangedKustomization(t *testing.T) {
+	const image = "gcr.io/foo/app"
+
+	testcases := []struct {
+		name     string
+		original string
+		expected string
+		oldTags  []string
+	}{
+		{
+			name: "update newTag by name",
+			original: `resources:
+  - ../../base
+images:
+  - name: gcr.io/foo/other
+    newTag: old
+  - name: gcr.io/foo/app
+    newTag: "old" # pinned by flow
+`,
+			expected: `resources:
+  - ../../base
+images:
+  - name: gcr.io/foo/other
+    newTag: old
+  - name: gcr.io/foo/app
+    newTag: "new" # pinned by flow
+`,
+			oldTags: []string{"old"},
+		},
+		{
+			name: "update newTag by newName",
+			original: `images:
+- name: app
+  newName: gcr.io/foo/app
+  newTag: old
+`,
+			expected: `images:
+- name: app
+  newName: gcr.io/foo/app
+  newTag: new
+`,
+			oldTags: []string{"old"},
+		},
+		{
+			name: "add newTag to entry",
+			original: `images:
+  - name: gcr.io/foo/app
+    digest: sha256:abc
+namespace: app
+`,
+			expected: `images:
+  - name: gcr.io/foo/app
+    newTag: new
+namespace: app
+`,
+			oldTags: []string{""},
+		},
+		{
+			name: "remove digest of first key",
+			original: `images:
+- digest: sha256:abc
+  name: gcr.io/foo/app
+  newTag: old # pinned by flow
+`,
+			expected: `images:
+- name: gcr.io/foo/app
+  newTag: new # pinned by flow
+`,
+			oldTags: []string{"old"},
+		},
+		{
+			name: "add entry",
+			original: `images:
+  - name: gcr.io/foo/other
+    newTag: old
+namespace: app
+`,
+			expected: `images:
+  - name: gcr.io/foo/other
+    newTag: old
+  - name: gcr.io/foo/app
+    newTag: new
+namespace: app
+`,
+			oldTags: []string{""},
+		},
+		{
+			name: "add entry to empty images",
+			original: `images: []
+namespace: app
+`,
+			expected: `images:
+- name: gcr.io/foo/app
+  newTag: new
+namespace: app
+`,
+			oldTags: []string{""},
+		},
+		{
+			name: "add images",
+			original: `resources:
+- ../../base`,
+			expected: `resources:
+- ../../base
+images:
+- name: gcr.io/foo/app
+  newTag: new
+`,
+			oldTags: []string{""},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			oldTags := []string{}
+			result, err := getChangedKustomization(tc.original, image, func(value string) string {
+				oldTags = append(oldTags, value)
+				return "new"
+			}, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, result)
+			assert.Equal(t, tc.oldTags, oldTags)
+		})
+	}
+
+	result, err := getChangedKustomization(`images:
+- name: gcr.io/foo/app
+  newTag: old
+`, image, func(string) string { return "new" }, func(string) string { return "sha256:new" })
+	assert.Nil(t, err)
+	assert.Equal(t, `images:
+- name: gcr.io/foo/app
+  newTag: new
+  digest: sha256:new
+`, result)
+
+	// versions that would be read as numbers are quoted
+	result, err = getChangedKustomization(`images:
+- name: gcr.io/foo/app
+  newTag: old
+- name: gcr.io/foo/app
+  newName: gcr.io/foo/app
+`, image, func(string) string { return "20261018" }, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, `images:
+- name: gcr.io/foo/app
+  newTag: "20261018"
+- name: gcr.io/foo/app
+  newName: gcr.io/foo/app
+  newTag: "20261018"
+`, result)
+
+	result, err = getChangedKustomization("resources: []\n", image, func(string) string { return "1.10" }, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "resources: []\nimages:\n- name: gcr.io/foo/app\n  newTag: \"1.10\"\n", result)
+
+	_, err = getChangedKustomization("images: [{name: gcr.io/foo/app}]\n", image, func(string) string { return "new" }, nil)
+	assert.NotNil(t, err)
+}
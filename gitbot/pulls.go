@@ -0,0 +1,78 @@
+package gitbot
+
+import (
+	"context"
+
+	"github.com/google/go-github/v75/github"
+	"github.com/ubie-oss/flow/v4/tracing"
+)
+
+// ListOpenPullRequests returns the open pull requests into the base branch of the repository.
+func ListOpenPullRequests(ctx context.Context, client *github.Client, owner, repo, base string) (prs []*github.PullRequest, err error) {
+	ctx, span := tracing.Start(ctx, "gitbot.ListOpenPullRequests", tracing.RepoKey.String(owner+"/"+repo))
+	defer func() { tracing.End(span, err) }()
+
+	opts := &github.PullRequestListOptions{
+		State:       "open",
+		Base:        base,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		page, res, err := client.PullRequests.List(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		prs = append(prs, page...)
+		if res.NextPage == 0 {
+			return prs, nil
+		}
+		opts.Page = res.NextPage
+	}
+}
+
+// FindOpenPullRequest returns the open pull request from the branch of the repository into the base branch, or nil.
+func FindOpenPullRequest(ctx context.Context, client *github.Client, owner, repo, base, branch string) (pr *github.PullRequest, err error) {
+	ctx, span := tracing.Start(ctx, "gitbot.FindOpenPullRequest", tracing.RepoKey.String(owner+"/"+repo))
+	defer func() { tracing.End(span, err) }()
+
+	// PRs from forks have other owners, so they are not the ones of the branch
+	prs, _, err := client.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{
+		State: "open",
+		Head:  owner + ":" + branch,
+		Base:  base,
+	})
+	if err != nil || len(prs) == 0 {
+		return nil, err
+	}
+	return prs[0], nil
+}
+
+// ClosePullRequest closes the pull request with the comment and deletes its branch.
+func ClosePullRequest(ctx context.Context, client *github.Client, owner, repo string, pr *github.PullRequest, comment string) (err error) {
+	ctx, span := tracing.Start(ctx, "gitbot.ClosePullRequest", tracing.RepoKey.String(owner+"/"+repo))
+	defer func() { tracing.End(span, err) }()
+
+	if comment != "" {
+		if _, _, err := client.Issues.CreateComment(ctx, owner, repo, pr.GetNumber(), &github.IssueComment{Body: github.Ptr(comment)}); err != nil {
+			return err
+		}
+	}
+	if _, _, err := client.PullRequests.Edit(ctx, owner, repo, pr.GetNumber(), &github.PullRequest{State: github.Ptr("closed")}); err != nil {
+		return err
+	}
+	_, err = client.Git.DeleteRef(ctx, owner, repo, "heads/"+pr.GetHead().GetRef())
+	return err
+}
+
+// HasLabel reports whether the PR has the label. It is false for an empty label, which no PR has.
+func HasLabel(pr *github.PullRequest, label string) bool {
+	if label == "" {
+		return false
+	}
+	for _, l := range pr.Labels {
+		if l.GetName() == label {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+package gitbot
+
+import (
+	"testing"
+
+	"github.com/google/go-github/v75/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasLabel(t *testing.T) {
+	pr := &github.PullRequest{Labels: []*github.Label{{Name: github.Ptr("flow")}, {Name: github.Ptr("")}}}
+
+	assert.True(t, HasLabel(pr, "flow"))
+	assert.False(t, HasLabel(pr, "downgrade"))
+	// an empty label, such as a downgrade label not configured, is never on PRs
+	assert.False(t, HasLabel(pr, ""))
+	assert.False(t, HasLabel(&github.PullRequest{}, "flow"))
+}
@@ -3,25 +3,43 @@ package gitbot
 import (
 	"context"
 	"errors"
+	"sort"
 
 	"github.com/dlclark/regexp2"
 	"github.com/google/go-github/v75/github"
+	"github.com/ubie-oss/flow/v4/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type release struct {
-	repo              Repo
-	author            Author
-	message           string
-	body              string
-	labels            []string
+	repo    Repo
+	author  Author
+	message string
+	body    string
+	labels  []string
+	// staleLabels are the labels flow may have added to the PR before, removed when the PR is updated without them
+	staleLabels []string
+	commitSHA   string
+	// errs keeps the first error of each file in the Make*Change functions
+	errs              map[string]error
 	changedContentMap map[string]string
+	// originalContentMap keeps the contents in the base branch to render diffs
+	originalContentMap map[string]string
 }
 
 type Release interface {
 	MakeChange(ctx context.Context, client *github.Client, filePath, regexText, changedText string)
 	MakeChangeFunc(ctx context.Context, client *github.Client, filePath, regexText string, evaluator regexp2.MatchEvaluator)
+	MakeYAMLChangeFunc(ctx context.Context, client *github.Client, filePath string, paths []string, evaluator ValueEvaluator)
+	MakeKustomizeChangeFunc(ctx context.Context, client *github.Client, filePath, image string, tagEvaluator, digestEvaluator ValueEvaluator)
+	MakeHelmChangeFunc(ctx context.Context, client *github.Client, filePath, image string, tagEvaluator, digestEvaluator ValueEvaluator)
 	Commit(ctx context.Context, client *github.Client) error
 	CreatePR(ctx context.Context, client *github.Client) (*string, error)
+	CreateOrUpdatePR(ctx context.Context, client *github.Client) (*string, error)
+	Diffs() map[string]string
+	GetCommitSHA() string
+	Err() error
 
 	GetRepo() *Repo
 	SetRepo(repo Repo)
@@ -33,6 +51,8 @@ type Release interface {
 	SetBody(string)
 	GetLabels() []string
 	SetLabels([]string)
+	GetStaleLabels() []string
+	SetStaleLabels([]string)
 }
 
 type Repo struct {
@@ -40,6 +60,9 @@ type Repo struct {
 	SourceRepo   string
 	BaseBranch   string
 	CommitBranch string
+	// ForceUpdate resets CommitBranch to BaseBranch on every commit, so that a long-lived
+	// branch has only the latest change on top of the base branch.
+	ForceUpdate bool
 }
 
 var _ Release = &release{}
@@ -51,12 +74,14 @@ type Author struct {
 
 func NewRelease(repo Repo, author Author, message string, body string, labels []string) Release {
 	return &release{
-		repo:              repo,
-		author:            author,
-		message:           message,
-		body:              body,
-		labels:            labels,
-		changedContentMap: make(map[string]string),
+		repo:               repo,
+		author:             author,
+		message:            message,
+		body:               body,
+		labels:             labels,
+		errs:               make(map[string]error),
+		changedContentMap:  make(map[string]string),
+		originalContentMap: make(map[string]string),
 	}
 }
 
@@ -68,7 +93,22 @@ func (r *release) MakeChangeFunc(ctx context.Context, client *github.Client, fil
 	r.makeChange(ctx, client, filePath, regexText, evaluator)
 }
 
-func (r *release) Commit(ctx context.Context, client *github.Client) error {
+func (r *release) MakeYAMLChangeFunc(ctx context.Context, client *github.Client, filePath string, paths []string, evaluator ValueEvaluator) {
+	r.makeYAMLChange(ctx, client, filePath, paths, evaluator)
+}
+
+func (r *release) MakeKustomizeChangeFunc(ctx context.Context, client *github.Client, filePath, image string, tagEvaluator, digestEvaluator ValueEvaluator) {
+	r.makeKustomizeChange(ctx, client, filePath, image, tagEvaluator, digestEvaluator)
+}
+
+func (r *release) MakeHelmChangeFunc(ctx context.Context, client *github.Client, filePath, image string, tagEvaluator, digestEvaluator ValueEvaluator) {
+	r.makeHelmChange(ctx, client, filePath, image, tagEvaluator, digestEvaluator)
+}
+
+func (r *release) Commit(ctx context.Context, client *github.Client) (err error) {
+	ctx, span := r.startSpan(ctx, "gitbot.Commit")
+	defer func() { tracing.End(span, err) }()
+
 	ref, err := r.getRef(ctx, client)
 	if err != nil {
 		return err
@@ -85,17 +125,58 @@ func (r *release) Commit(ctx context.Context, client *github.Client) error {
 	return r.pushCommit(ctx, client, ref, tree)
 }
 
-func (r *release) CreatePR(ctx context.Context, client *github.Client) (*string, error) {
+func (r *release) CreatePR(ctx context.Context, client *github.Client) (url *string, err error) {
+	ctx, span := r.startSpan(ctx, "gitbot.CreatePR")
+	defer func() { tracing.End(span, err) }()
 	return r.createPR(ctx, client)
 }
 
-func (r *release) GetRepo() *Repo            { return &r.repo }
-func (r *release) SetRepo(repo Repo)         { r.repo = repo }
-func (r *release) GetAuthor() *Author        { return &r.author }
-func (r *release) SetAuthor(author Author)   { r.author = author }
-func (r *release) GetMessage() string        { return r.message }
-func (r *release) SetMessage(s string)       { r.message = s }
-func (r *release) GetBody() string           { return r.body }
-func (r *release) SetBody(s string)          { r.body = s }
-func (r *release) GetLabels() []string       { return r.labels }
-func (r *release) SetLabels(labels []string) { r.labels = labels }
+// CreateOrUpdatePR rewrites the open PR of the commit branch, or creates one if there is none.
+func (r *release) CreateOrUpdatePR(ctx context.Context, client *github.Client) (url *string, err error) {
+	ctx, span := r.startSpan(ctx, "gitbot.CreateOrUpdatePR")
+	defer func() { tracing.End(span, err) }()
+	return r.updatePR(ctx, client)
+}
+
+// startSpan starts a span of an operation on the repository, wrapping the spans of its GitHub requests.
+func (r *release) startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, name,
+		tracing.RepoKey.String(r.repo.SourceOwner+"/"+r.repo.SourceRepo),
+		attribute.String("flow.branch", r.repo.CommitBranch),
+	)
+}
+
+// Diffs returns unified diffs of the changed files keyed by their paths, without committing them.
+func (r *release) Diffs() map[string]string {
+	return r.diffs()
+}
+
+// GetCommitSHA returns the SHA of the commit pushed by Commit.
+func (r *release) GetCommitSHA() string { return r.commitSHA }
+
+// Err returns the errors fetching or rewriting the files in the Make*Change functions, or nil.
+func (r *release) Err() error {
+	paths := make([]string, 0, len(r.errs))
+	for path := range r.errs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	errs := make([]error, 0, len(paths))
+	for _, path := range paths {
+		errs = append(errs, r.errs[path])
+	}
+	return errors.Join(errs...)
+}
+
+func (r *release) GetRepo() *Repo                 { return &r.repo }
+func (r *release) SetRepo(repo Repo)              { r.repo = repo }
+func (r *release) GetAuthor() *Author             { return &r.author }
+func (r *release) SetAuthor(author Author)        { r.author = author }
+func (r *release) GetMessage() string             { return r.message }
+func (r *release) SetMessage(s string)            { r.message = s }
+func (r *release) GetBody() string                { return r.body }
+func (r *release) SetBody(s string)               { r.body = s }
+func (r *release) GetLabels() []string            { return r.labels }
+func (r *release) SetLabels(labels []string)      { r.labels = labels }
+func (r *release) GetStaleLabels() []string       { return r.staleLabels }
+func (r *release) SetStaleLabels(labels []string) { r.staleLabels = labels }
@@ -0,0 +1,336 @@
+package gitbot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValueEvaluator returns the new value of a scalar given its current value.
+type ValueEvaluator func(value string) string
+
+type yamlSelector struct {
+	index    int
+	wildcard bool
+	key      string
+	value    string
+}
+
+type yamlPathSegment struct {
+	key       string
+	selectors []yamlSelector
+}
+
+type yamlEdit struct {
+	offset int
+	length int
+	text   string
+}
+
+// parseYAMLPath parses a yq-style path such as spec.template.spec.containers[name=app].image.
+// Selectors can be an index ([0]), a wildcard ([*]) or a key/value match ([name=app]).
+func parseYAMLPath(path string) ([]yamlPathSegment, error) {
+	var segments []yamlPathSegment
+	for _, part := range splitYAMLPath(path) {
+		if part == "" {
+			return nil, fmt.Errorf("empty segment in path %q", path)
+		}
+		seg := yamlPathSegment{}
+		key, rest, _ := strings.Cut(part, "[")
+		seg.key = key
+		if rest != "" {
+			rest = "[" + rest
+		}
+		for rest != "" {
+			end := strings.Index(rest, "]")
+			if !strings.HasPrefix(rest, "[") || end < 0 {
+				return nil, fmt.Errorf("invalid selector %q in path %q", rest, path)
+			}
+			sel, err := parseYAMLSelector(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("%w in path %q", err, path)
+			}
+			seg.selectors = append(seg.selectors, sel)
+			rest = rest[end+1:]
+		}
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
+
+// splitYAMLPath splits path by dots outside of brackets.
+func splitYAMLPath(path string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range path {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '.':
+			if depth == 0 {
+				parts = append(parts, path[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, path[start:])
+}
+
+func parseYAMLSelector(s string) (yamlSelector, error) {
+	if s == "*" {
+		return yamlSelector{wildcard: true}, nil
+	}
+	if key, value, ok := strings.Cut(s, "="); ok {
+		if key == "" {
+			return yamlSelector{}, fmt.Errorf("invalid selector [%s]", s)
+		}
+		return yamlSelector{key: key, value: strings.Trim(value, `"'`)}, nil
+	}
+	index, err := strconv.Atoi(s)
+	if err != nil || index < 0 {
+		return yamlSelector{}, fmt.Errorf("invalid selector [%s]", s)
+	}
+	return yamlSelector{index: index}, nil
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+func findYAMLNodes(node *yaml.Node, segments []yamlPathSegment) []*yaml.Node {
+	node = resolveAlias(node)
+	if node == nil {
+		return nil
+	}
+	if node.Kind == yaml.DocumentNode {
+		var found []*yaml.Node
+		for _, child := range node.Content {
+			found = append(found, findYAMLNodes(child, segments)...)
+		}
+		return found
+	}
+	if len(segments) == 0 {
+		return []*yaml.Node{node}
+	}
+
+	seg := segments[0]
+	current := []*yaml.Node{node}
+	if seg.key != "" {
+		current = nil
+		if node.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == seg.key {
+					current = append(current, resolveAlias(node.Content[i+1]))
+				}
+			}
+		}
+	}
+	for _, sel := range seg.selectors {
+		var next []*yaml.Node
+		for _, n := range current {
+			if n.Kind != yaml.SequenceNode {
+				continue
+			}
+			for i, item := range n.Content {
+				item = resolveAlias(item)
+				if sel.wildcard || (sel.key == "" && sel.index == i) || (sel.key != "" && hasYAMLField(item, sel.key, sel.value)) {
+					next = append(next, item)
+				}
+			}
+		}
+		current = next
+	}
+
+	var found []*yaml.Node
+	for _, n := range current {
+		found = append(found, findYAMLNodes(n, segments[1:])...)
+	}
+	return found
+}
+
+func hasYAMLField(node *yaml.Node, key, value string) bool {
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			v := resolveAlias(node.Content[i+1])
+			return v.Kind == yaml.ScalarNode && v.Value == value
+		}
+	}
+	return false
+}
+
+// lineOffsets returns the byte offset of the beginning of each line.
+func lineOffsets(text string) []int {
+	offsets := []int{0}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			offsets = append(offsets, i+1)
+		}
+	}
+	return offsets
+}
+
+// columnOffset returns the offset of the column of the line in the text.
+func columnOffset(original string, offsets []int, line, column int) int {
+	offset := offsets[line-1]
+	// columns count characters, not bytes
+	for col := 1; col < column && offset < len(original); col++ {
+		_, size := utf8.DecodeRuneInString(original[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// scalarEdit computes the edit that replaces the scalar node in the original text without
+// touching anything around it, so comments and formatting are preserved.
+func scalarEdit(original string, offsets []int, node *yaml.Node, value string) (yamlEdit, error) {
+	if node.Kind != yaml.ScalarNode {
+		return yamlEdit{}, fmt.Errorf("line %d: not a scalar", node.Line)
+	}
+	if node.Line < 1 || node.Line > len(offsets) {
+		return yamlEdit{}, fmt.Errorf("line %d: out of range", node.Line)
+	}
+	offset := columnOffset(original, offsets, node.Line, node.Column)
+	// skip an anchor or a tag in front of the value
+	for offset < len(original) && (original[offset] == '&' || original[offset] == '!') {
+		end := strings.IndexAny(original[offset:], " \t")
+		if end < 0 {
+			return yamlEdit{}, fmt.Errorf("line %d: unexpected end of value", node.Line)
+		}
+		offset += end
+		for offset < len(original) && (original[offset] == ' ' || original[offset] == '\t') {
+			offset++
+		}
+	}
+
+	rest := original[offset:]
+	switch node.Style {
+	case yaml.DoubleQuotedStyle:
+		for i := 1; i < len(rest); i++ {
+			switch rest[i] {
+			case '\\':
+				i++
+			case '"':
+				return yamlEdit{offset: offset, length: i + 1, text: strconv.Quote(value)}, nil
+			case '\n':
+				return yamlEdit{}, fmt.Errorf("line %d: multi-line scalars are not supported", node.Line)
+			}
+		}
+	case yaml.SingleQuotedStyle:
+		for i := 1; i < len(rest); i++ {
+			if rest[i] == '\'' {
+				if i+1 < len(rest) && rest[i+1] == '\'' {
+					i++
+					continue
+				}
+				return yamlEdit{offset: offset, length: i + 1, text: "'" + strings.ReplaceAll(value, "'", "''") + "'"}, nil
+			}
+			if rest[i] == '\n' {
+				return yamlEdit{}, fmt.Errorf("line %d: multi-line scalars are not supported", node.Line)
+			}
+		}
+	case 0:
+		if strings.HasPrefix(rest, node.Value) {
+			return yamlEdit{offset: offset, length: len(node.Value), text: plainScalar(value)}, nil
+		}
+	case yaml.TaggedStyle:
+		// the explicit tag decides the type of the value
+		if strings.HasPrefix(rest, node.Value) {
+			return yamlEdit{offset: offset, length: len(node.Value), text: value}, nil
+		}
+	}
+	return yamlEdit{}, fmt.Errorf("line %d: unsupported scalar style", node.Line)
+}
+
+// plainScalar returns the value as a plain scalar if it is read back as the same string,
+// or double-quoted otherwise, so that tags such as 1.10 or 20261018 do not become numbers.
+func plainScalar(value string) string {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &doc); err == nil && len(doc.Content) == 1 {
+		if node := doc.Content[0]; node.Kind == yaml.ScalarNode && node.Style == 0 && node.Tag == "!!str" && node.Value == value {
+			return value
+		}
+	}
+	return strconv.Quote(value)
+}
+
+// getChangedYAML rewrites the scalars found at paths in every document of original.
+// Only the values themselves are replaced, so comments, ordering and formatting stay as they are.
+func getChangedYAML(original string, paths []string, evaluator ValueEvaluator) (string, error) {
+	var docs []*yaml.Node
+	dec := yaml.NewDecoder(strings.NewReader(original))
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return original, err
+		}
+		docs = append(docs, &doc)
+	}
+
+	offsets := lineOffsets(original)
+	edits := map[int]yamlEdit{}
+	for _, path := range paths {
+		segments, err := parseYAMLPath(path)
+		if err != nil {
+			return original, err
+		}
+		for _, doc := range docs {
+			for _, node := range findYAMLNodes(doc, segments) {
+				value := evaluator(node.Value)
+				if value == node.Value {
+					continue
+				}
+				edit, err := scalarEdit(original, offsets, node, value)
+				if err != nil {
+					return original, fmt.Errorf("%s: %w", path, err)
+				}
+				// the same node can be reached more than once through aliases
+				edits[edit.offset] = edit
+			}
+		}
+	}
+
+	return applyEdits(original, edits), nil
+}
+
+func applyEdits(original string, edits map[int]yamlEdit) string {
+	sorted := make([]yamlEdit, 0, len(edits))
+	for _, edit := range edits {
+		sorted = append(sorted, edit)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].offset < sorted[j].offset })
+
+	var buf bytes.Buffer
+	last := 0
+	for _, edit := range sorted {
+		buf.WriteString(original[last:edit.offset])
+		buf.WriteString(edit.text)
+		last = edit.offset + edit.length
+	}
+	buf.WriteString(original[last:])
+	return buf.String()
+}
+
+// ValidateYAMLPath returns an error if the path cannot be parsed.
+func ValidateYAMLPath(path string) error {
+	_, err := parseYAMLPath(path)
+	return err
+}
@@ -0,0 +1,178 @@
+package gitbot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetChangedYAML(t *testing.T) {
+	const image = "gcr.io/foo/app"
+	evaluator := func(value string) string {
+		if strings.HasPrefix(value, image+":") {
+			return image + ":new"
+		}
+		return "new"
+	}
+
+	testcases := []struct {
+		name     string
+		paths    []string
+		original string
+		expected string
+	}{
+		{
+			name:  "select container by name",
+			paths: []string{"spec.template.spec.containers[name=app].image"},
+			original: `# deployment
+apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+        - name: sidecar
+          image: gcr.io/foo/app:old # sidecar uses the same image
+        - name: app
+          image:   gcr.io/foo/app:old   # keep this comment
+`,
+			expected: `# deployment
+apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+        - name: sidecar
+          image: gcr.io/foo/app:old # sidecar uses the same image
+        - name: app
+          image:   gcr.io/foo/app:new   # keep this comment
+`,
+		},
+		{
+			name:  "multiple documents and quotes",
+			paths: []string{"metadata.labels.version", "spec.containers[0].image"},
+			original: `metadata:
+  labels:
+    version: "old"
+---
+metadata:
+  labels:
+    version: 'old'
+spec:
+  containers:
+  - image: "gcr.io/foo/app:old"
+`,
+			expected: `metadata:
+  labels:
+    version: "new"
+---
+metadata:
+  labels:
+    version: 'new'
+spec:
+  containers:
+  - image: "gcr.io/foo/app:new"
+`,
+		},
+		{
+			name:  "anchor and alias",
+			paths: []string{"a.version", "b.version"},
+			original: `a:
+  version: &v old
+b:
+  version: *v
+c: old
+`,
+			expected: `a:
+  version: &v new
+b:
+  version: *v
+c: old
+`,
+		},
+		{
+			name:  "wildcard and missing keys",
+			paths: []string{"items[*].tag", "missing.tag"},
+			original: `items:
+  - tag: old
+  - name: none
+  - tag: old
+tag: old
+`,
+			expected: `items:
+  - tag: new
+  - name: none
+  - tag: new
+tag: old
+`,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := getChangedYAML(tc.original, tc.paths, evaluator)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+}
+
+func TestGetChangedYAMLQuote(t *testing.T) {
+	original := `a: old
+b: "old"
+c: !!str old
+d: gcr.io/foo/app:old
+`
+	expected := `a: "1.10"
+b: "1.10"
+c: !!str 1.10
+d: gcr.io/foo/app:1.10
+`
+	result, err := getChangedYAML(original, []string{"a", "b", "c", "d"}, func(value string) string {
+		if strings.HasPrefix(value, "gcr.io/foo/app:") {
+			return "gcr.io/foo/app:1.10"
+		}
+		return "1.10"
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, result)
+}
+
+func TestPlainScalar(t *testing.T) {
+	testcases := []struct {
+		value    string
+		expected string
+	}{
+		{value: "v1.10", expected: "v1.10"},
+		{value: "main-abc1234", expected: "main-abc1234"},
+		{value: "sha256:abc", expected: "sha256:abc"},
+		{value: "1.10", expected: `"1.10"`},
+		{value: "20261018", expected: `"20261018"`},
+		{value: "1e3", expected: `"1e3"`},
+		{value: "true", expected: `"true"`},
+		{value: "null", expected: `"null"`},
+		{value: "", expected: `""`},
+		{value: "a: b", expected: `"a: b"`},
+		{value: "#1", expected: `"#1"`},
+	}
+	for _, tc := range testcases {
+		assert.Equal(t, tc.expected, plainScalar(tc.value), tc.value)
+	}
+}
+
+func TestGetChangedYAMLErrors(t *testing.T) {
+	evaluator := func(string) string { return "new" }
+
+	_, err := getChangedYAML("a: [", []string{"a"}, evaluator)
+	assert.NotNil(t, err)
+
+	_, err = getChangedYAML("a: b\n", []string{"a[x"}, evaluator)
+	assert.NotNil(t, err)
+
+	_, err = getChangedYAML("a: |\n  old\n", []string{"a"}, evaluator)
+	assert.NotNil(t, err)
+
+	_, err = getChangedYAML("a:\n  b: c\n", []string{"a"}, evaluator)
+	assert.NotNil(t, err)
+}
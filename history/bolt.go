@@ -0,0 +1,164 @@
+package history
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	rolloutsBucket = []byte("rollouts")
+	// createdBucket indexes the IDs of the records by their creation times, the oldest first,
+	// so that the oldest ones are evicted without reading them all. Its sequence is the number of records.
+	createdBucket = []byte("created")
+)
+
+// BoltStore keeps the most recently created records in a BoltDB file, so that they survive restarts.
+// The file is locked by the process which opens it.
+type BoltStore struct {
+	db   *bolt.DB
+	size int
+}
+
+var _ Store = &BoltStore{}
+
+// NewBoltStore opens or creates the BoltDB file at path, keeping up to size records and evicting the oldest ones.
+func NewBoltStore(path string, size int) (*BoltStore, error) {
+	if size <= 0 {
+		size = 1
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(rolloutsBucket)
+		if err != nil {
+			return err
+		}
+		if tx.Bucket(createdBucket) != nil {
+			return nil
+		}
+		// files written before the index are indexed once
+		index, err := tx.CreateBucket(createdBucket)
+		if err != nil {
+			return err
+		}
+		records, err := readRecords(b)
+		if err != nil {
+			return err
+		}
+		for _, r := range records {
+			if err := index.Put(createdKey(r), []byte(r.ID)); err != nil {
+				return err
+			}
+		}
+		return index.SetSequence(uint64(len(records)))
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create the bucket in %s: %w", path, err)
+	}
+	return &BoltStore{db: db, size: size}, nil
+}
+
+// Close closes the file.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStore) Put(_ context.Context, r *Record) error {
+	prepare(r)
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, index := tx.Bucket(rolloutsBucket), tx.Bucket(createdBucket)
+		n := index.Sequence()
+		if prev := b.Get([]byte(r.ID)); prev != nil {
+			var old Record
+			if err := json.Unmarshal(prev, &old); err != nil {
+				return fmt.Errorf("failed to decode rollout %s: %w", r.ID, err)
+			}
+			if err := index.Delete(createdKey(&old)); err != nil {
+				return err
+			}
+		} else {
+			n++
+		}
+		if err := b.Put([]byte(r.ID), data); err != nil {
+			return err
+		}
+		if err := index.Put(createdKey(r), []byte(r.ID)); err != nil {
+			return err
+		}
+
+		// the cursor moves to the next key on deletes, so the oldest key is looked up each time
+		c := index.Cursor()
+		for k, id := c.First(); k != nil && n > uint64(s.size); k, id = c.First() {
+			if err := b.Delete(id); err != nil {
+				return err
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			n--
+		}
+		return index.SetSequence(n)
+	})
+}
+
+func (s *BoltStore) Get(_ context.Context, id string) (*Record, error) {
+	var r *Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(rolloutsBucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		r = &Record{}
+		return json.Unmarshal(data, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *BoltStore) List(_ context.Context, filter Filter) ([]Record, error) {
+	var records map[string]*Record
+	err := s.db.View(func(tx *bolt.Tx) (err error) {
+		records, err = readRecords(tx.Bucket(rolloutsBucket))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortRecords(records, filter), nil
+}
+
+// createdKey returns the key of the record in createdBucket, which sorts by the creation time and then by the ID
+// like sortRecords in reverse.
+func createdKey(r *Record) []byte {
+	key := make([]byte, 8, 8+len(r.ID))
+	binary.BigEndian.PutUint64(key, uint64(r.CreatedAt.UnixNano()))
+	return append(key, r.ID...)
+}
+
+// readRecords decodes the records in the bucket by their IDs.
+func readRecords(b *bolt.Bucket) (map[string]*Record, error) {
+	records := map[string]*Record{}
+	err := b.ForEach(func(k, v []byte) error {
+		var r Record
+		if err := json.Unmarshal(v, &r); err != nil {
+			return fmt.Errorf("failed to decode rollout %s: %w", k, err)
+		}
+		records[r.ID] = &r
+		return nil
+	})
+	return records, err
+}
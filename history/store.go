@@ -0,0 +1,187 @@
+// Package history records the rollouts of flow, to tell which version was last proposed to an environment
+// and what became of it without searching GitHub.
+package history
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by Get for unknown IDs.
+var ErrNotFound = errors.New("rollout not found")
+
+// Status is the state of a rollout to a manifest.
+type Status string
+
+const (
+	// StatusRunning is set while the rollout is being pushed.
+	StatusRunning Status = "running"
+	// StatusCommitted is set when the version is pushed without a PR.
+	StatusCommitted Status = "committed"
+	// StatusOpened is set when the PR of the version is opened or updated.
+	StatusOpened Status = "opened"
+	// StatusMerged is set when the PR is merged.
+	StatusMerged Status = "merged"
+	// StatusClosed is set when the PR is closed without being merged.
+	StatusClosed Status = "closed"
+	// StatusChecksFailed is set when the checks of the PR failed.
+	// The check suites failing are kept in FailedChecks until each of them passes again.
+	StatusChecksFailed Status = "checks_failed"
+	// StatusSkipped is set when nothing is pushed, e.g. for a downgrade.
+	StatusSkipped Status = "skipped"
+	// StatusFailed is set when an attempt failed. It is the final status if no attempt is left.
+	StatusFailed Status = "failed"
+)
+
+// Record is the rollout of a version of an image to a manifest.
+type Record struct {
+	ID             string    `json:"id"`
+	App            string    `json:"app"`
+	Image          string    `json:"image"`
+	Tag            string    `json:"tag"`
+	Digest         string    `json:"digest,omitempty"`
+	Env            string    `json:"env"`
+	Owner          string    `json:"owner"`
+	Repo           string    `json:"repo"`
+	BaseBranch     string    `json:"base_branch"`
+	Branch         string    `json:"branch,omitempty"`
+	PullRequestURL string    `json:"pull_request_url,omitempty"`
+	CommitSHA      string    `json:"commit_sha,omitempty"`
+	Status         Status    `json:"status"`
+	Attempt        int       `json:"attempt,omitempty"`
+	MessageID      string    `json:"message_id,omitempty"`
+	Skipped        string    `json:"skipped,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	FailedChecks   []string  `json:"failed_checks,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Filter selects records in List. Empty fields match any record.
+type Filter struct {
+	App    string
+	Image  string
+	Env    string
+	Status Status
+	// Owner and Repo are matched case-insensitively, like GitHub does.
+	Owner  string
+	Repo   string
+	Branch string
+	// Limit is the maximum number of records, all of them if not positive.
+	Limit int
+}
+
+func (f Filter) match(r *Record) bool {
+	return (f.App == "" || f.App == r.App) &&
+		(f.Image == "" || f.Image == r.Image) &&
+		(f.Env == "" || f.Env == r.Env) &&
+		(f.Status == "" || f.Status == r.Status) &&
+		(f.Owner == "" || strings.EqualFold(f.Owner, r.Owner)) &&
+		(f.Repo == "" || strings.EqualFold(f.Repo, r.Repo)) &&
+		(f.Branch == "" || f.Branch == r.Branch)
+}
+
+// Store keeps the records of rollouts.
+type Store interface {
+	// Put adds the record, or replaces the one with the same ID. It sets the ID and CreatedAt of new records,
+	// and UpdatedAt.
+	Put(ctx context.Context, r *Record) error
+	// Get returns the record with the ID, or ErrNotFound.
+	Get(ctx context.Context, id string) (*Record, error)
+	// List returns the records matching the filter, the most recent first.
+	List(ctx context.Context, filter Filter) ([]Record, error)
+}
+
+// MemoryStore keeps the most recently created records in memory.
+type MemoryStore struct {
+	size int
+
+	mu      sync.Mutex
+	records map[string]*Record
+}
+
+var _ Store = &MemoryStore{}
+
+// NewMemoryStore returns a Store keeping up to size records, evicting the oldest ones.
+func NewMemoryStore(size int) *MemoryStore {
+	if size <= 0 {
+		size = 1
+	}
+	return &MemoryStore{size: size, records: map[string]*Record{}}
+}
+
+func (s *MemoryStore) Put(_ context.Context, r *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prepare(r)
+	c := *r
+	s.records[r.ID] = &c
+	if len(s.records) > s.size {
+		oldest := sortRecords(s.records, Filter{})
+		for _, o := range oldest[s.size:] {
+			delete(s.records, o.ID)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Get(_ context.Context, id string) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *r
+	return &c, nil
+}
+
+func (s *MemoryStore) List(_ context.Context, filter Filter) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortRecords(s.records, filter), nil
+}
+
+// prepare sets the ID and timestamps of the record before it is stored.
+func prepare(r *Record) {
+	now := time.Now()
+	if r.ID == "" {
+		r.ID = newID()
+	}
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = now
+	}
+	r.UpdatedAt = now
+}
+
+// sortRecords returns copies of the records matching the filter, the most recent first.
+func sortRecords(records map[string]*Record, filter Filter) []Record {
+	list := []Record{}
+	for _, r := range records {
+		if filter.match(r) {
+			list = append(list, *r)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.After(list[j].CreatedAt)
+		}
+		return list[i].ID > list[j].ID
+	})
+	if filter.Limit > 0 && len(list) > filter.Limit {
+		list = list[:filter.Limit]
+	}
+	return list
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
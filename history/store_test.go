@@ -0,0 +1,155 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	records := []*Record{
+		{App: "app", Image: "app-image", Tag: "v1", Env: "production", Owner: "ubie-oss", Repo: "manifests", Branch: "rollout/production-app-v1-1", Status: StatusMerged, CreatedAt: created},
+		{App: "app", Image: "app-image", Tag: "v1", Env: "staging", Status: StatusMerged, CreatedAt: created.Add(time.Minute)},
+		{App: "other", Image: "other-image", Tag: "v9", Env: "production", Status: StatusOpened, CreatedAt: created.Add(2 * time.Minute)},
+		{App: "app", Image: "app-image", Tag: "v2", Env: "production", Status: StatusRunning, CreatedAt: created.Add(3 * time.Minute)},
+	}
+	for _, r := range records {
+		assert.Nil(t, s.Put(ctx, r))
+		assert.NotEmpty(t, r.ID)
+		assert.False(t, r.UpdatedAt.IsZero())
+	}
+
+	// updated in place
+	records[3].Status = StatusOpened
+	records[3].PullRequestURL = "https://github.com/ubie-oss/manifests/pull/1"
+	assert.Nil(t, s.Put(ctx, records[3]))
+	r, err := s.Get(ctx, records[3].ID)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusOpened, r.Status)
+	assert.Equal(t, "https://github.com/ubie-oss/manifests/pull/1", r.PullRequestURL)
+	assert.True(t, r.CreatedAt.Equal(created.Add(3*time.Minute)))
+
+	_, err = s.Get(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	tags := func(filter Filter) []string {
+		list, err := s.List(ctx, filter)
+		assert.Nil(t, err)
+		var tags []string
+		for _, r := range list {
+			tags = append(tags, r.Env+":"+r.Tag)
+		}
+		return tags
+	}
+	assert.Equal(t, []string{"production:v2", "production:v9", "staging:v1", "production:v1"}, tags(Filter{}))
+	assert.Equal(t, []string{"production:v2", "production:v1"}, tags(Filter{App: "app", Env: "production"}))
+	assert.Equal(t, []string{"production:v2"}, tags(Filter{App: "app", Env: "production", Limit: 1}))
+	assert.Equal(t, []string{"staging:v1", "production:v1"}, tags(Filter{Image: "app-image", Status: StatusMerged}))
+	assert.Equal(t, []string{"production:v1"}, tags(Filter{Owner: "Ubie-OSS", Repo: "manifests", Branch: "rollout/production-app-v1-1"}))
+	assert.Nil(t, tags(Filter{Env: "qa"}))
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore(10))
+
+	// the oldest records are evicted
+	ctx := context.Background()
+	s := NewMemoryStore(2)
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, tag := range []string{"v1", "v2", "v3"} {
+		assert.Nil(t, s.Put(ctx, &Record{Tag: tag, CreatedAt: created.Add(time.Duration(i) * time.Minute)}))
+	}
+	list, _ := s.List(ctx, Filter{})
+	assert.Len(t, list, 2)
+	assert.Equal(t, "v3", list[0].Tag)
+	assert.Equal(t, "v2", list[1].Tag)
+}
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	s, err := NewBoltStore(path, 10)
+	assert.Nil(t, err)
+	testStore(t, s)
+	assert.Nil(t, s.Close())
+
+	// reopened after a restart
+	s, err = NewBoltStore(path, 2)
+	assert.Nil(t, err)
+	defer s.Close()
+	list, err := s.List(context.Background(), Filter{})
+	assert.Nil(t, err)
+	assert.Len(t, list, 4)
+
+	// the oldest records are evicted
+	ctx := context.Background()
+	assert.Nil(t, s.Put(ctx, &Record{Tag: "v3", CreatedAt: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)}))
+	list, err = s.List(ctx, Filter{})
+	assert.Nil(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, "v3", list[0].Tag)
+	assert.Equal(t, "v2", list[1].Tag)
+}
+
+func TestBoltStoreEviction(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "history.db")
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// a file written before the index of creation times
+	db, err := bolt.Open(path, 0o600, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket(rolloutsBucket)
+		if err != nil {
+			return err
+		}
+		for i, tag := range []string{"v1", "v2"} {
+			data, _ := json.Marshal(Record{ID: tag, Tag: tag, CreatedAt: created.Add(time.Duration(i) * time.Minute)})
+			if err := b.Put([]byte(tag), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	assert.Nil(t, db.Close())
+
+	s, err := NewBoltStore(path, 3)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	// updates are not counted as new records
+	v3 := &Record{Tag: "v3", CreatedAt: created.Add(2 * time.Minute)}
+	for range 3 {
+		assert.Nil(t, s.Put(ctx, v3))
+	}
+	list, err := s.List(ctx, Filter{})
+	assert.Nil(t, err)
+	assert.Len(t, list, 3)
+
+	// v1 is the oldest
+	assert.Nil(t, s.Put(ctx, &Record{Tag: "v4", CreatedAt: created.Add(3 * time.Minute)}))
+	_, err = s.Get(ctx, "v1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// v2 is created again after v3, which is evicted instead
+	v2, err := s.Get(ctx, "v2")
+	assert.Nil(t, err)
+	v2.CreatedAt = created.Add(4 * time.Minute)
+	assert.Nil(t, s.Put(ctx, v2))
+	assert.Nil(t, s.Put(ctx, &Record{Tag: "v5", CreatedAt: created.Add(5 * time.Minute)}))
+	list, err = s.List(ctx, Filter{})
+	assert.Nil(t, err)
+	var tags []string
+	for _, r := range list {
+		tags = append(tags, r.Tag)
+	}
+	assert.Equal(t, []string{"v5", "v2", "v4"}, tags)
+}
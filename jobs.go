@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ubie-oss/flow/v4/flow"
+	"github.com/ubie-oss/flow/v4/queue"
+	"github.com/ubie-oss/flow/v4/tracing"
+)
+
+const defaultQueueSize = 1000
+
+// newQueue returns the queue processing rollouts in the background if FLOW_QUEUE_WORKERS is set, or nil.
+// The queue keeps its jobs in FLOW_QUEUE_JOURNAL.
+func newQueue() (*queue.Queue, error) {
+	workers, _ := strconv.Atoi(os.Getenv("FLOW_QUEUE_WORKERS"))
+	if workers <= 0 {
+		return nil, nil
+	}
+	size := defaultQueueSize
+	if s, err := strconv.Atoi(os.Getenv("FLOW_QUEUE_SIZE")); err == nil && s > 0 {
+		size = s
+	}
+	// events are acknowledged once their rollouts are enqueued, so the rollouts must survive restarts
+	journal := os.Getenv("FLOW_QUEUE_JOURNAL")
+	if journal == "" {
+		return nil, errors.New("FLOW_QUEUE_JOURNAL is required with FLOW_QUEUE_WORKERS")
+	}
+	return queue.New(processRolloutJob, queue.Options{
+		Workers:     workers,
+		Size:        size,
+		MaxAttempts: 5,
+		Backoff:     5 * time.Second,
+		JournalPath: journal,
+		GiveUp:      giveUpRolloutJob,
+	})
+}
+
+// rolloutJob is the payload of a queued rollout. Jobs enqueued before TraceContext was added have none.
+type rolloutJob struct {
+	flow.Rollout
+	// TraceContext is the trace context of the request which enqueued the rollout.
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+}
+
+// processRolloutJob processes a queued rollout. Only transient failures are returned to try it again.
+func processRolloutJob(ctx context.Context, job queue.Job) (err error) {
+	var payload rolloutJob
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		slog.Error("Failed to decode rollout job", "job_id", job.ID, "error", err)
+		return nil
+	}
+	ctx, span := tracing.StartLinked(ctx, "processRolloutJob", payload.TraceContext, tracing.JobIDKey.String(job.ID))
+	defer func() { tracing.End(span, err) }()
+
+	rollout := payload.Rollout
+	rollout.Options.Retried = true
+	span.SetAttributes(
+		tracing.MessageIDKey.String(rollout.Options.MessageID),
+		tracing.ImageKey.String(rollout.Image),
+		tracing.VersionKey.String(rollout.Version),
+	)
+	results, err := f.ProcessRollouts(ctx, []flow.Rollout{rollout})
+	// the job is canceled by the shutdown and resumed from the journal, whatever the rollout failed with
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err = errors.Join(err, results.Err()); err != nil {
+		if flow.IsTransient(err) {
+			return err
+		}
+		slog.Error("Rollout job failed", "job_id", job.ID, "image", rollout.Image, "version", rollout.Version, "error", err)
+	}
+	return nil
+}
+
+// giveUpRolloutJob records the rollout failed and notifies it when its job runs out of attempts.
+func giveUpRolloutJob(ctx context.Context, job queue.Job, attempts int, err error) {
+	var payload rolloutJob
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return
+	}
+	f.RecordFailure(ctx, payload.Rollout, attempts, err)
+}
+
+// dispatchRollouts processes the rollouts and responds with the results, or enqueues them and responds with 202
+// as soon as they are enqueued if the queue is enabled. Dry runs are processed in place to respond with the plans.
+// It returns the error of the rollouts or of enqueuing them, which is already in the response, for the span of the caller.
+func dispatchRollouts(w http.ResponseWriter, r *http.Request, rollouts []flow.Rollout) error {
+	if q == nil || (len(rollouts) > 0 && rollouts[0].Options.DryRun) {
+		results, err := f.ProcessRollouts(r.Context(), rollouts)
+		renderResponse(w, r, newResponse(results, err))
+		return errors.Join(err, results.Err())
+	}
+
+	res := &Response{Status: http.StatusAccepted}
+	var err error
+	for _, rollout := range rollouts {
+		var id string
+		id, err = q.Enqueue(rolloutJob{Rollout: rollout, TraceContext: tracing.Carrier(r.Context())})
+		if err != nil {
+			// the event is delivered again, and the rollouts enqueued already are skipped by their message IDs
+			slog.Error("Failed to enqueue rollout", "image", rollout.Image, "version", rollout.Version, "error", err)
+			res.Status = http.StatusServiceUnavailable
+			res.Error = err.Error()
+			break
+		}
+		slog.Info("Enqueued rollout", "job_id", id, "image", rollout.Image, "version", rollout.Version)
+		res.JobIDs = append(res.JobIDs, id)
+	}
+	renderResponse(w, r, res)
+	return err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ubie-oss/flow/v4/flow"
+	"github.com/ubie-oss/flow/v4/queue"
+	"golang.org/x/oauth2"
+)
+
+const testConfig = `applications:
+  - image: gcr.io/example/foo
+    source_owner: ubie-oss
+    source_name: foo
+    manifest_owner: ubie-oss
+    manifest_name: manifests
+    manifests:
+      - env: production
+        files:
+          - deployment.yaml
+`
+
+// redirectTransport sends the requests to the GitHub API to a fake server.
+type redirectTransport struct {
+	url *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.url.Scheme
+	req.URL.Host = t.url.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// setUpFlow sets up f with the config, and returns the client sending the GitHub requests to server,
+// which flow uses when it is set in the context with oauth2.HTTPClient.
+func setUpFlow(t *testing.T, config string, server *httptest.Server) *http.Client {
+	t.Setenv("FLOW_GITHUB_TOKEN", "token")
+	var err error
+	f, err = initFlow([]byte(config))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	u, _ := url.Parse(server.URL)
+	return &http.Client{Transport: redirectTransport{url: u}}
+}
+
+func TestNewQueue(t *testing.T) {
+	t.Setenv("FLOW_QUEUE_WORKERS", "")
+	jobs, err := newQueue()
+	assert.Nil(t, err)
+	assert.Nil(t, jobs)
+
+	// acknowledged rollouts must not be lost on restarts
+	t.Setenv("FLOW_QUEUE_WORKERS", "2")
+	_, err = newQueue()
+	assert.EqualError(t, err, "FLOW_QUEUE_JOURNAL is required with FLOW_QUEUE_WORKERS")
+
+	t.Setenv("FLOW_QUEUE_JOURNAL", filepath.Join(t.TempDir(), "journal"))
+	jobs, err = newQueue()
+	assert.Nil(t, err)
+	assert.Nil(t, jobs.Shutdown(context.Background()))
+}
+
+func TestProcessRolloutJobShutdown(t *testing.T) {
+	// GitHub does not respond until the rollout is canceled
+	started := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	client := setUpFlow(t, testConfig, server)
+
+	path := filepath.Join(t.TempDir(), "journal")
+	jobs, err := queue.New(func(ctx context.Context, job queue.Job) error {
+		return processRolloutJob(context.WithValue(ctx, oauth2.HTTPClient, client), job)
+	}, queue.Options{JournalPath: path, MaxAttempts: 5, Backoff: time.Millisecond})
+	assert.Nil(t, err)
+	_, err = jobs.Enqueue(rolloutJob{Rollout: flow.Rollout{Image: "gcr.io/example/foo", Version: "v1", Options: flow.Options{MessageID: "1"}}})
+	assert.Nil(t, err)
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, jobs.Shutdown(ctx), context.DeadlineExceeded)
+
+	// the rollout canceled by the shutdown is resumed after a restart
+	var resumed []string
+	jobs, err = queue.New(func(ctx context.Context, job queue.Job) error {
+		var payload rolloutJob
+		assert.Nil(t, json.Unmarshal(job.Payload, &payload))
+		resumed = append(resumed, payload.Image+":"+payload.Version)
+		return nil
+	}, queue.Options{JournalPath: path})
+	assert.Nil(t, err)
+	assert.Nil(t, jobs.Shutdown(context.Background()))
+	assert.Equal(t, []string{"gcr.io/example/foo:v1"}, resumed)
+}
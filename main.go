@@ -1,38 +1,74 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sakajunquality/cloud-pubsub-events/gcrevent"
+	"github.com/ubie-oss/flow/v4/auth"
 	"github.com/ubie-oss/flow/v4/flow"
-
-	"gopkg.in/yaml.v3"
+	"github.com/ubie-oss/flow/v4/queue"
+	"github.com/ubie-oss/flow/v4/tracing"
 )
 
 // Response is a HTTP response
 type Response struct {
-	Status int `json:"status"`
+	Status  int           `json:"status"`
+	Results []flow.Result `json:"results,omitempty"`
+	// JobIDs are the IDs of the rollouts enqueued to be processed in the background
+	JobIDs []string `json:"job_ids,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// newResponse returns the response to a pushed event. It is 503 if the event failed transiently so that
+// the sender delivers it again, and 200 otherwise, even on permanent failures which redelivering cannot fix.
+func newResponse(results flow.Results, err error) *Response {
+	res := &Response{
+		Status:  http.StatusOK,
+		Results: results,
+	}
+	if err = errors.Join(err, results.Err()); err != nil {
+		res.Error = err.Error()
+		if flow.IsTransient(err) {
+			res.Status = http.StatusServiceUnavailable
+		}
+	}
+	return res
+}
+
+func renderResponse(w http.ResponseWriter, r *http.Request, res *Response) {
+	render.Status(r, res.Status)
+	render.JSON(w, r, res)
 }
 
 // PubSubMessage is a Push message from Cloud Pub/Sub
 type PubSubMessage struct {
 	Message struct {
-		Data []byte `json:"data,omitempty"`
-		ID   string `json:"id"`
+		Data      []byte `json:"data,omitempty"`
+		ID        string `json:"id"`
+		MessageID string `json:"messageId"`
 	} `json:"message"`
 	Subscription string `json:"subscription"`
 }
 
 var (
 	f *flow.Flow
+	// q is nil if the rollouts are processed in the handlers
+	q *queue.Queue
 )
 
 func main() {
@@ -40,43 +76,160 @@ func main() {
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	slog.SetDefault(logger)
 
-	cfg, err := getConfig()
+	// serve without a subcommand to keep the container command working
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "serve":
+		err = runServe(args)
+	case "rollout":
+		err = runRollout(args)
+	case "plan":
+		err = runPlan(args)
+	case "validate":
+		err = runValidate(args)
+	case "help":
+		usage()
+		return
+	default:
+		usage()
+		os.Exit(2)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not read the file:%s.\n", err)
+		fmt.Fprintf(os.Stderr, "%s.\n", err)
 		os.Exit(1)
 	}
+}
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("FLOW_CONFIG_PATH"), "path to the config file")
+	_ = fs.Parse(args)
+
+	cfg, err := getConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("could not read the file: %w", err)
+	}
 
 	f, err = initFlow(cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing the config %s.\n", err)
-		os.Exit(1)
+		return fmt.Errorf("error parsing the config: %w", err)
+	}
+
+	shutdownTracing, err := tracing.Init(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+
+	verifier, err := newPubSubVerifier()
+	if err != nil {
+		return err
+	}
+
+	q, err = newQueue()
+	if err != nil {
+		return fmt.Errorf("failed to start the queue: %w", err)
 	}
 
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
 	r.Use(middleware.Logger)
-	r.Post("/", handlePubSubMessage)
+	r.Use(limitBody)
+	r.Use(tracing.Middleware)
+	r.Get("/healthz", handleHealthz)
+	r.Get("/readyz", handleReadyz)
+	r.Handle("/metrics", promhttp.Handler())
+	r.Group(func(r chi.Router) {
+		if verifier != nil {
+			r.Use(verifier.Middleware)
+		}
+		r.Post("/", handlePubSubMessage)
+	})
+	if handler := newGitHubWebhookHandler(); handler != nil {
+		r.Post("/github/webhook", handler)
+	}
+	if tokens := splitEnv("FLOW_REGISTRY_TOKENS"); len(tokens) > 0 {
+		r.With(auth.StaticTokens(tokens).Middleware).Post("/registry/notifications", handleRegistryNotification)
+	}
+	if tokens := splitEnv("FLOW_API_TOKENS"); len(tokens) > 0 {
+		r.Group(func(r chi.Router) {
+			r.Use(auth.StaticTokens(tokens).Middleware)
+			r.Post("/api/v1/rollouts", handleRollout)
+			r.Get("/api/v1/rollouts", handleListRollouts)
+			r.Get("/api/v1/rollouts/{id}", handleGetRollout)
+		})
+	}
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
-	slog.Info("Starting server", "port", port)
-	if err := http.ListenAndServe(":"+port, r); err != nil {
-		slog.Error("Failed to start server", "error", err)
-		os.Exit(1)
+	srv := newServer(":"+port, r)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Starting server", "port", port)
+		serveErr <- srv.ListenAndServe()
+	}()
+	ready.Store(true)
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("failed to start server: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdown(srv, q, shutdownTracing)
+	return nil
+}
+
+// newPubSubVerifier returns the verifier of Pub/Sub push tokens, or nil if FLOW_PUBSUB_AUTH is disabled.
+// FLOW_PUBSUB_AUDIENCE is required otherwise, so that flow does not accept unauthenticated pushes by mistake.
+func newPubSubVerifier() (*auth.OIDCVerifier, error) {
+	if os.Getenv("FLOW_PUBSUB_AUTH") == "disabled" {
+		slog.Warn("Pub/Sub push authentication is disabled, anyone reaching flow can trigger rollouts")
+		return nil, nil
+	}
+	audience := os.Getenv("FLOW_PUBSUB_AUDIENCE")
+	if audience == "" {
+		return nil, errors.New("FLOW_PUBSUB_AUDIENCE is required to authenticate Pub/Sub push, set FLOW_PUBSUB_AUTH=disabled to accept unauthenticated requests")
+	}
+	jwksURL := os.Getenv("FLOW_PUBSUB_JWKS_URL")
+	if jwksURL == "" {
+		jwksURL = auth.GoogleJWKSURL
+	}
+	return &auth.OIDCVerifier{
+		Audience:        audience,
+		ServiceAccounts: splitEnv("FLOW_PUBSUB_SERVICE_ACCOUNTS"),
+		Keys:            auth.NewJWKSKeySource(jwksURL, nil, time.Hour),
+	}, nil
+}
+
+// splitEnv returns the comma separated values of the environment variable.
+func splitEnv(key string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
 	}
+	return values
 }
 
-func getConfig() ([]byte, error) {
-	return os.ReadFile(os.Getenv("FLOW_CONFIG_PATH"))
+func getConfig(path string) ([]byte, error) {
+	return os.ReadFile(path)
 }
 
 func initFlow(config []byte) (*flow.Flow, error) {
-	cfg := new(flow.Config)
-	if err := yaml.Unmarshal(config, cfg); err != nil {
-		return nil, fmt.Errorf("yaml.Unmarshal error: %w", err)
+	cfg, err := flow.LoadConfig(config)
+	if err != nil {
+		return nil, err
 	}
 	f, err := flow.New(cfg)
 	if err != nil {
@@ -87,13 +240,9 @@ func initFlow(config []byte) (*flow.Flow, error) {
 }
 
 func handlePubSubMessage(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-
 	var m PubSubMessage
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		slog.Error("Failed to read request body", "error", err)
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+	body, ok := readBody(w, r)
+	if !ok {
 		return
 	}
 
@@ -103,20 +252,38 @@ func handlePubSubMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	_ = processPubSubMessage(w, r, m)
+}
+
+// processPubSubMessage rolls out the GCR event in the message and responds with the results.
+// It returns the error of the event or of its rollouts, which is recorded in the span of the message.
+func processPubSubMessage(w http.ResponseWriter, r *http.Request, m PubSubMessage) (err error) {
+	ctx, span := tracing.Start(r.Context(), "handlePubSubMessage", tracing.MessageIDKey.String(m.Message.MessageID))
+	defer func() { tracing.End(span, err) }()
+	r = r.WithContext(ctx)
+
 	event, err := gcrevent.ParseMessage(m.Message.Data)
 	if err != nil {
 		slog.Error("Failed to parse GCR event", "error", err)
 		http.Error(w, "Bad Request", http.StatusBadRequest)
-		return
+		return err
 	}
 
-	err = f.ProcessGCREvent(ctx, event)
+	// dry run can be requested per subscription with a push endpoint like /?dry_run=true
+	opts := flow.Options{
+		DryRun:     r.URL.Query().Get("dry_run") == "true",
+		MessageID:  m.Message.MessageID,
+		ReceivedAt: time.Now(),
+	}
+	rollouts, err := flow.GCREventRollouts(event, opts)
+	flow.CountEvent("pubsub", rollouts)
 	if err != nil {
 		slog.Error("Failed to process GCR event", "error", err)
+		renderResponse(w, r, newResponse(nil, err))
+		return err
 	}
-
-	res := &Response{
-		Status: http.StatusOK,
+	for _, rollout := range rollouts {
+		span.SetAttributes(tracing.ImageKey.String(rollout.Image), tracing.VersionKey.String(rollout.Version))
 	}
-	render.JSON(w, r, res)
+	return dispatchRollouts(w, r, rollouts)
 }
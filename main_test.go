@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/oauth2"
+)
+
+func pubSubMessage(id, data string) string {
+	return `{"message": {"data": "` + base64.StdEncoding.EncodeToString([]byte(data)) + `", "messageId": "` + id + `"}, "subscription": "projects/example/subscriptions/flow"}`
+}
+
+func TestHandlePubSubMessage(t *testing.T) {
+	// GitHub fails every request with the status of the test case
+	var githubStatus int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(githubStatus)
+		_, _ = w.Write([]byte(`{"message": "error"}`))
+	}))
+	defer server.Close()
+	client := setUpFlow(t, testConfig, server)
+	q = nil
+	t.Setenv("FLOW_MAX_BODY_BYTES", "1024")
+	handler := limitBody(http.HandlerFunc(handlePubSubMessage))
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(nil) })
+
+	tests := []struct {
+		name         string
+		body         string
+		githubStatus int
+		want         int
+		// wantResults are the envs of the results, which tell that the event was decoded
+		wantResults []string
+		wantError   bool
+	}{
+		{name: "too large", body: pubSubMessage("1", strings.Repeat("x", 1024)), want: http.StatusRequestEntityTooLarge},
+		{name: "malformed message", body: `{"message":`, want: http.StatusBadRequest},
+		{name: "malformed event", body: pubSubMessage("2", "not an event"), want: http.StatusBadRequest},
+		{name: "not an insert", body: pubSubMessage("3", `{"action": "DELETE", "tag": "gcr.io/example/foo:v1"}`), want: http.StatusOK},
+		{name: "invalid tag", body: pubSubMessage("4", `{"action": "INSERT", "tag": "gcr.io/example/foo"}`), want: http.StatusOK, wantError: true},
+		{name: "unknown image", body: pubSubMessage("5", `{"action": "INSERT", "tag": "gcr.io/example/bar:v1"}`), want: http.StatusOK, wantError: true},
+		{
+			name:         "permanent failure",
+			body:         pubSubMessage("6", `{"action": "INSERT", "digest": "gcr.io/example/foo@sha256:1", "tag": "gcr.io/example/foo:v1"}`),
+			githubStatus: http.StatusNotFound,
+			want:         http.StatusOK,
+			wantResults:  []string{"production"},
+			wantError:    true,
+		},
+		{
+			name:         "transient failure",
+			body:         pubSubMessage("7", `{"action": "INSERT", "digest": "gcr.io/example/foo@sha256:2", "tag": "gcr.io/example/foo:v2"}`),
+			githubStatus: http.StatusBadGateway,
+			want:         http.StatusServiceUnavailable,
+			wantResults:  []string{"production"},
+			wantError:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			githubStatus = tt.githubStatus
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req = req.WithContext(context.WithValue(req.Context(), oauth2.HTTPClient, client))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want, w.Code)
+			if w.Header().Get("Content-Type") != "application/json" {
+				return
+			}
+			var res Response
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+			assert.Equal(t, tt.want, res.Status)
+			assert.Equal(t, tt.wantError, res.Error != "")
+			var envs []string
+			for _, result := range res.Results {
+				envs = append(envs, result.Env)
+				assert.NotEmpty(t, result.Error)
+			}
+			assert.Equal(t, tt.wantResults, envs)
+
+			// the span of the message records the error of the event or of its rollouts
+			spans := recorder.Ended()
+			span := spans[len(spans)-1]
+			assert.Equal(t, "handlePubSubMessage", span.Name())
+			if tt.wantError {
+				assert.Equal(t, codes.Error, span.Status().Code)
+			} else {
+				assert.Equal(t, codes.Unset, span.Status().Code)
+			}
+		})
+	}
+}
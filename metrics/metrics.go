@@ -0,0 +1,130 @@
+// Package metrics defines the Prometheus metrics of flow, served at /metrics.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "flow"
+
+var (
+	// EventsReceived counts the events received by source, e.g. pubsub, github, registry and api,
+	// and by the image and env of each manifest they roll out to. Events without a configured
+	// application, such as the ones of PRs, have empty image and env.
+	EventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_received_total",
+		Help:      "Number of events received.",
+	}, []string{"source", "image", "env"})
+
+	// RolloutsFiltered counts the versions not rolled out to the manifests by their filters.
+	RolloutsFiltered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rollouts_filtered_total",
+		Help:      "Number of versions filtered out of the manifests.",
+	}, []string{"image", "env"})
+
+	Commits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commits_total",
+		Help:      "Number of commits pushed to the manifest repositories.",
+	}, []string{"image", "env"})
+
+	PullRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pull_requests_total",
+		Help:      "Number of pull requests opened or updated.",
+	}, []string{"image", "env"})
+
+	AutoMerges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auto_merges_total",
+		Help:      "Number of pull requests merged automatically.",
+	}, []string{"image", "env"})
+
+	// PullRequestOutcomes counts what became of the PRs of rollouts, reported by GitHub webhooks:
+	// merged, closed or checks_failed.
+	PullRequestOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pull_request_outcomes_total",
+		Help:      "Number of pull requests merged, closed or failing their checks.",
+	}, []string{"image", "env", "outcome"})
+
+	// Failures counts the rollouts failed after all the attempts by reason, such as rate_limited or network.
+	Failures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "failures_total",
+		Help:      "Number of rollouts failed.",
+	}, []string{"image", "env", "reason"})
+
+	Attempts = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rollout_attempts",
+		Help:      "Number of attempts of rollouts to the manifests.",
+		Buckets:   []float64{1, 2, 3, 5, 10},
+	}, []string{"image", "env"})
+
+	// RolloutDuration observes the time from receiving the event until the rollout to the manifest is done.
+	RolloutDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rollout_duration_seconds",
+		Help:      "Time from receiving an event until the rollout to the manifest is done.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12),
+	}, []string{"image", "env"})
+
+	GitHubRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "github_requests_total",
+		Help:      "Number of requests to the GitHub API.",
+	}, []string{"method", "code"})
+
+	GitHubRateLimitRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "github_rate_limit_remaining",
+		Help:      "Number of requests remaining in the current GitHub rate limit window.",
+	}, []string{"resource"})
+
+	GitHubRateLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "github_rate_limit",
+		Help:      "Number of requests allowed in a GitHub rate limit window.",
+	}, []string{"resource"})
+)
+
+// githubTransport records the requests to GitHub and the rate limits in their responses.
+type githubTransport struct {
+	next http.RoundTripper
+}
+
+// GitHubTransport wraps next to record the requests to GitHub and their rate limits.
+func GitHubTransport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &githubTransport{next: next}
+}
+
+func (t *githubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		GitHubRequests.WithLabelValues(req.Method, "error").Inc()
+		return res, err
+	}
+	GitHubRequests.WithLabelValues(req.Method, strconv.Itoa(res.StatusCode)).Inc()
+
+	resource := res.Header.Get("X-RateLimit-Resource")
+	if resource == "" {
+		resource = "core"
+	}
+	if remaining, err := strconv.ParseFloat(res.Header.Get("X-RateLimit-Remaining"), 64); err == nil {
+		GitHubRateLimitRemaining.WithLabelValues(resource).Set(remaining)
+	}
+	if limit, err := strconv.ParseFloat(res.Header.Get("X-RateLimit-Limit"), 64); err == nil {
+		GitHubRateLimit.WithLabelValues(resource).Set(limit)
+	}
+	return res, nil
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGitHubTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "4321")
+		w.Header().Set("X-RateLimit-Resource", "core")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: GitHubTransport(nil)}
+	res, err := client.Post(server.URL, "application/json", nil)
+	assert.Nil(t, err)
+	res.Body.Close()
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(GitHubRequests.WithLabelValues(http.MethodPost, "201")))
+	assert.Equal(t, float64(4321), testutil.ToFloat64(GitHubRateLimitRemaining.WithLabelValues("core")))
+	assert.Equal(t, float64(5000), testutil.ToFloat64(GitHubRateLimit.WithLabelValues("core")))
+}
@@ -0,0 +1,183 @@
+// Package notify sends messages about rollouts to Slack incoming webhooks and generic JSON webhooks.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Types of targets.
+const (
+	TypeSlack   = "slack"
+	TypeWebhook = "webhook"
+)
+
+// Types of events.
+const (
+	EventOpened       = "pull_request_opened"
+	EventAutoMerged   = "auto_merged"
+	EventMerged       = "merged"
+	EventClosed       = "closed"
+	EventChecksFailed = "checks_failed"
+	EventFailed       = "failed"
+)
+
+// EventTypes are all the types of events.
+var EventTypes = []string{EventOpened, EventAutoMerged, EventMerged, EventClosed, EventChecksFailed, EventFailed}
+
+// SignatureHeader is the header of the HMAC-SHA256 signature of webhook payloads, sha256=<hex> like GitHub's.
+const SignatureHeader = "X-Flow-Signature-256"
+
+// EventHeader is the header of the type of the event of webhook payloads.
+const EventHeader = "X-Flow-Event"
+
+const defaultTimeout = 10 * time.Second
+
+// defaultTemplates are the messages of the events when targets have no template.
+var defaultTemplates = map[string]string{
+	EventOpened:       "Opened a PR to roll out {{.Image}}:{{.Tag}} to {{.Env}}: {{.PullRequestURL}}",
+	EventAutoMerged:   "Auto-merged the rollout of {{.Image}}:{{.Tag}} to {{.Env}}: {{.PullRequestURL}}",
+	EventMerged:       "Merged the rollout of {{.Image}}:{{.Tag}} to {{.Env}}: {{.PullRequestURL}}",
+	EventClosed:       "Closed the PR rolling out {{.Image}}:{{.Tag}} to {{.Env}} without merging it: {{.PullRequestURL}}",
+	EventChecksFailed: "Checks failed on the PR rolling out {{.Image}}:{{.Tag}} to {{.Env}}: {{.PullRequestURL}}",
+	EventFailed:       "Failed to roll out {{.Image}}:{{.Tag}} to {{.Env}} after {{.Attempts}} attempts: {{.Error}}",
+}
+
+// Event is something that happened to the rollout of a version to a manifest.
+type Event struct {
+	Type           string `json:"type"`
+	App            string `json:"app"`
+	Image          string `json:"image"`
+	Tag            string `json:"tag"`
+	Env            string `json:"env"`
+	Owner          string `json:"owner"`
+	Repo           string `json:"repo"`
+	PullRequestURL string `json:"pull_request_url,omitempty"`
+	CommitSHA      string `json:"commit_sha,omitempty"`
+	Attempts       int    `json:"attempts,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// Target is where the events are sent.
+type Target struct {
+	// Type is TypeSlack to post the message to an incoming webhook, or TypeWebhook to post the event as JSON.
+	Type string
+	URL  string
+	// Secret signs the payloads of TypeWebhook in SignatureHeader if it is not empty.
+	Secret string
+	// Events are the types of events sent, all of them if empty.
+	Events []string
+	// Template is a text/template of the message rendered with the Event, a default one of each type if empty.
+	Template string
+}
+
+// Wants reports whether the events of the type are sent to the target.
+func (t Target) Wants(eventType string) bool {
+	return len(t.Events) == 0 || slices.Contains(t.Events, eventType)
+}
+
+// ParseTemplate parses the template of a message.
+func ParseTemplate(text string) (*template.Template, error) {
+	return template.New("message").Option("missingkey=error").Parse(text)
+}
+
+// Message renders the message of the event with the template of the target.
+func (t Target) Message(e Event) (string, error) {
+	text := t.Template
+	if text == "" {
+		text = defaultTemplates[e.Type]
+	}
+	tmpl, err := ParseTemplate(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the template: %w", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, e); err != nil {
+		return "", fmt.Errorf("failed to render the template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// Notifier sends events to targets.
+type Notifier struct {
+	client *http.Client
+}
+
+// New returns a Notifier sending the requests with the client, or a client with a timeout of 10s if nil.
+func New(client *http.Client) *Notifier {
+	if client == nil {
+		client = &http.Client{Timeout: defaultTimeout}
+	}
+	return &Notifier{client: client}
+}
+
+type slackPayload struct {
+	Text string `json:"text"`
+}
+
+type webhookPayload struct {
+	Event
+	Message string `json:"message"`
+}
+
+// Send sends the event to the target.
+func (n *Notifier) Send(ctx context.Context, t Target, e Event) error {
+	message, err := t.Message(e)
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+	switch t.Type {
+	case TypeSlack:
+		body, err = json.Marshal(slackPayload{Text: message})
+	case TypeWebhook:
+		body, err = json.Marshal(webhookPayload{Event: e, Message: message})
+	default:
+		return fmt.Errorf("unknown notification type %q", t.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.Type == TypeWebhook {
+		req.Header.Set(EventHeader, e.Type)
+		if t.Secret != "" {
+			req.Header.Set(SignatureHeader, Sign([]byte(t.Secret), body))
+		}
+	}
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("%s notification responded %s", t.Type, res.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature of the payload in SignatureHeader, for receivers to verify it.
+func Sign(secret, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
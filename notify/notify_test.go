@@ -0,0 +1,89 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+func newServer(t *testing.T, status int) (*httptest.Server, *[]request) {
+	var requests []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, request{header: r.Header, body: body})
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+var event = Event{
+	Type:           EventOpened,
+	App:            "foo",
+	Image:          "gcr.io/example/foo",
+	Tag:            "v1.2.3",
+	Env:            "production",
+	Owner:          "ubie-oss",
+	Repo:           "manifests",
+	PullRequestURL: "https://github.com/ubie-oss/manifests/pull/1",
+}
+
+func TestSendSlack(t *testing.T) {
+	server, requests := newServer(t, http.StatusOK)
+	n := New(nil)
+
+	assert.Nil(t, n.Send(context.Background(), Target{Type: TypeSlack, URL: server.URL}, event))
+	assert.Nil(t, n.Send(context.Background(), Target{Type: TypeSlack, URL: server.URL, Template: ":rocket: {{.App}} {{.Tag}} → {{.Env}}"}, event))
+
+	assert.Len(t, *requests, 2)
+	assert.JSONEq(t, `{"text": "Opened a PR to roll out gcr.io/example/foo:v1.2.3 to production: https://github.com/ubie-oss/manifests/pull/1"}`, string((*requests)[0].body))
+	assert.JSONEq(t, `{"text": ":rocket: foo v1.2.3 → production"}`, string((*requests)[1].body))
+}
+
+func TestSendWebhook(t *testing.T) {
+	server, requests := newServer(t, http.StatusNoContent)
+	n := New(nil)
+
+	assert.Nil(t, n.Send(context.Background(), Target{Type: TypeWebhook, URL: server.URL, Secret: "secret"}, event))
+	assert.Len(t, *requests, 1)
+	req := (*requests)[0]
+	assert.Equal(t, EventOpened, req.header.Get(EventHeader))
+	assert.Equal(t, Sign([]byte("secret"), req.body), req.header.Get(SignatureHeader))
+
+	var payload map[string]interface{}
+	assert.Nil(t, json.Unmarshal(req.body, &payload))
+	assert.Equal(t, "pull_request_opened", payload["type"])
+	assert.Equal(t, "v1.2.3", payload["tag"])
+	assert.Equal(t, "Opened a PR to roll out gcr.io/example/foo:v1.2.3 to production: https://github.com/ubie-oss/manifests/pull/1", payload["message"])
+
+	// unsigned without a secret
+	assert.Nil(t, n.Send(context.Background(), Target{Type: TypeWebhook, URL: server.URL}, event))
+	assert.Empty(t, (*requests)[1].header.Get(SignatureHeader))
+}
+
+func TestSendErrors(t *testing.T) {
+	server, _ := newServer(t, http.StatusInternalServerError)
+	n := New(nil)
+
+	err := n.Send(context.Background(), Target{Type: TypeSlack, URL: server.URL}, event)
+	assert.EqualError(t, err, "slack notification responded 500 Internal Server Error")
+
+	err = n.Send(context.Background(), Target{Type: TypeSlack, URL: server.URL, Template: "{{.Unknown}}"}, event)
+	assert.ErrorContains(t, err, "failed to render the template")
+}
+
+func TestWants(t *testing.T) {
+	assert.True(t, Target{}.Wants(EventFailed))
+	assert.True(t, Target{Events: []string{EventOpened, EventFailed}}.Wants(EventFailed))
+	assert.False(t, Target{Events: []string{EventOpened}}.Wants(EventFailed))
+}
@@ -0,0 +1,149 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// journal is an append-only file of the jobs enqueued and done, a JSON object per line.
+type journal struct {
+	path string
+	file *os.File
+}
+
+type journalEntry struct {
+	Add  *Job   `json:"add,omitempty"`
+	Done string `json:"done,omitempty"`
+}
+
+// openJournal reads the jobs not done yet from the file at path, and rewrites it only with them.
+func openJournal(path string) (*journal, []Job, error) {
+	jobs, err := readJournal(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	j := &journal{path: path}
+	if err := j.rewrite(jobs); err != nil {
+		return nil, nil, err
+	}
+	return j, jobs, nil
+}
+
+func readJournal(path string) ([]Job, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var order []string
+	pending := map[string]Job{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// the last line may be half written when the process was killed
+			continue
+		}
+		switch {
+		case entry.Add != nil:
+			order = append(order, entry.Add.ID)
+			pending[entry.Add.ID] = *entry.Add
+		case entry.Done != "":
+			delete(pending, entry.Done)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var jobs []Job
+	for _, id := range order {
+		if job, ok := pending[id]; ok {
+			jobs = append(jobs, job)
+			delete(pending, id)
+		}
+	}
+	return jobs, nil
+}
+
+func (j *journal) add(job Job) error {
+	return j.write(journalEntry{Add: &job})
+}
+
+// done records the job done, and truncates the file if no job is left.
+func (j *journal) done(id string, empty bool) error {
+	if empty {
+		return j.rewrite(nil)
+	}
+	return j.write(journalEntry{Done: id})
+}
+
+func (j *journal) write(entry journalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	// the job must survive a crash once it is acknowledged
+	return j.file.Sync()
+}
+
+// rewrite replaces the file with the jobs, through a temporary file so that it is never half written.
+func (j *journal) rewrite(jobs []Job) error {
+	if j.file != nil {
+		_ = j.file.Close()
+		j.file = nil
+	}
+
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for i := range jobs {
+		data, err := json.Marshal(journalEntry{Add: &jobs[i]})
+		if err != nil {
+			f.Close()
+			return err
+		}
+		_, _ = w.Write(append(data, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+
+	j.file, err = os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	return err
+}
+
+func (j *journal) close() error {
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
@@ -0,0 +1,238 @@
+// Package queue runs jobs in the background with a bounded number of workers,
+// so that events can be acknowledged before they are processed.
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrFull is returned by Enqueue when the queue has as many jobs as its size.
+	ErrFull = errors.New("queue is full")
+	// ErrClosed is returned by Enqueue after Shutdown.
+	ErrClosed = errors.New("queue is closed")
+)
+
+// Job is a unit of work with a JSON payload, so that it can be kept in the journal.
+type Job struct {
+	ID      string          `json:"id"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Handler processes a job. Jobs failing with an error are tried again with a backoff up to Options.MaxAttempts times.
+type Handler func(ctx context.Context, job Job) error
+
+// Options are options of a Queue.
+type Options struct {
+	// Workers is the number of jobs processed at the same time, 1 if not positive.
+	Workers int
+	// Size is the maximum number of jobs waiting or running, unlimited if not positive.
+	Size int
+	// MaxAttempts is the number of times a failing job is tried, 1 if not positive.
+	MaxAttempts int
+	// Backoff is the wait before the second attempt, doubled for each attempt after it. 1s if zero.
+	Backoff time.Duration
+	// JournalPath is the file keeping the jobs not done yet, so that they are resumed after restarts.
+	// Jobs are only kept in memory if empty.
+	JournalPath string
+	// GiveUp is called with the last error when a job fails MaxAttempts times, if not nil.
+	GiveUp func(ctx context.Context, job Job, attempts int, err error)
+}
+
+// Queue is a queue of jobs processed by a pool of workers.
+type Queue struct {
+	handler Handler
+	opts    Options
+	journal *journal
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	jobs    []Job
+	running int
+	closed  bool
+
+	// ctx is canceled when Shutdown gives up waiting, to stop the jobs running
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New starts the workers of a queue. Jobs left in the journal are enqueued again.
+func New(handler Handler, opts Options) (*Queue, error) {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 1
+	}
+	if opts.Backoff == 0 {
+		opts.Backoff = time.Second
+	}
+
+	q := &Queue{handler: handler, opts: opts}
+	q.cond = sync.NewCond(&q.mu)
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+
+	if opts.JournalPath != "" {
+		j, jobs, err := openJournal(opts.JournalPath)
+		if err != nil {
+			return nil, err
+		}
+		q.journal = j
+		q.jobs = jobs
+		if len(jobs) > 0 {
+			slog.Info("Resuming jobs from the journal", "count", len(jobs))
+		}
+	}
+
+	for i := 0; i < opts.Workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q, nil
+}
+
+// Enqueue adds a job with the payload marshaled as JSON and returns its ID.
+// The job is in the journal, if any, when it returns.
+func (q *Queue) Enqueue(payload interface{}) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	job := Job{ID: newID(), Payload: data}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return "", ErrClosed
+	}
+	if q.opts.Size > 0 && len(q.jobs)+q.running >= q.opts.Size {
+		return "", ErrFull
+	}
+	if q.journal != nil {
+		if err := q.journal.add(job); err != nil {
+			return "", err
+		}
+	}
+	q.jobs = append(q.jobs, job)
+	q.cond.Signal()
+	return job.ID, nil
+}
+
+// Len returns the number of jobs waiting or running.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.jobs) + q.running
+}
+
+// Shutdown stops accepting jobs and waits for the workers to finish the ones enqueued.
+// If ctx is done before, the running jobs are canceled and the waiting ones are left in the journal.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		q.mu.Lock()
+		left := len(q.jobs)
+		q.jobs = nil
+		q.cond.Broadcast()
+		q.mu.Unlock()
+		slog.Warn("Gave up waiting for jobs", "waiting", left)
+		q.cancel()
+		<-done
+	}
+	q.cancel()
+	if q.journal != nil {
+		if cerr := q.journal.close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for {
+		q.mu.Lock()
+		for len(q.jobs) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.jobs) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		job := q.jobs[0]
+		q.jobs = q.jobs[1:]
+		q.running++
+		q.mu.Unlock()
+
+		done := q.run(job)
+
+		q.mu.Lock()
+		q.running--
+		if done && q.journal != nil {
+			// the journal is truncated when nothing is left, but not while jobs are given up on shutdown
+			empty := len(q.jobs)+q.running == 0 && !q.closed
+			if err := q.journal.done(job.ID, empty); err != nil {
+				slog.Error("Failed to record the job done", "job_id", job.ID, "error", err)
+			}
+		}
+		q.mu.Unlock()
+	}
+}
+
+// run tries the job until it succeeds or runs out of attempts, and reports whether it is done.
+// It is not done if it is canceled by Shutdown, so that it is resumed from the journal.
+func (q *Queue) run(job Job) bool {
+	backoff := q.opts.Backoff
+	for attempt := 1; ; attempt++ {
+		err := q.handler(q.ctx, job)
+		// a job canceled by Shutdown is not done even if its handler returns nil
+		if q.ctx.Err() != nil {
+			return false
+		}
+		if err == nil {
+			return true
+		}
+		if attempt >= q.opts.MaxAttempts {
+			slog.Error("Job failed", "job_id", job.ID, "attempts", attempt, "error", err)
+			if q.opts.GiveUp != nil {
+				q.opts.GiveUp(q.ctx, job, attempt, err)
+			}
+			return true
+		}
+		slog.Warn("Job failed, trying again", "job_id", job.ID, "attempt", attempt, "backoff", backoff, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-q.ctx.Done():
+			return false
+		}
+		backoff *= 2
+	}
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
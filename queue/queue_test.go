@@ -0,0 +1,155 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueue(t *testing.T) {
+	var mu sync.Mutex
+	var processed []string
+	var running, maxRunning int32
+	q, err := New(func(ctx context.Context, job Job) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		var s string
+		_ = json.Unmarshal(job.Payload, &s)
+		mu.Lock()
+		processed = append(processed, s)
+		mu.Unlock()
+		return nil
+	}, Options{Workers: 2})
+	assert.Nil(t, err)
+
+	for _, s := range []string{"a", "b", "c", "d"} {
+		_, err := q.Enqueue(s)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, q.Shutdown(context.Background()))
+	assert.ElementsMatch(t, []string{"a", "b", "c", "d"}, processed)
+	assert.LessOrEqual(t, maxRunning, int32(2))
+
+	_, err = q.Enqueue("e")
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	q, err := New(func(ctx context.Context, job Job) error {
+		<-release
+		return nil
+	}, Options{Workers: 1, Size: 2})
+	assert.Nil(t, err)
+
+	_, err = q.Enqueue("a")
+	assert.Nil(t, err)
+	_, err = q.Enqueue("b")
+	assert.Nil(t, err)
+	_, err = q.Enqueue("c")
+	assert.ErrorIs(t, err, ErrFull)
+
+	close(release)
+	assert.Nil(t, q.Shutdown(context.Background()))
+}
+
+func TestQueueRetry(t *testing.T) {
+	var attempts int32
+	q, err := New(func(ctx context.Context, job Job) error {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	}, Options{MaxAttempts: 5, Backoff: time.Millisecond})
+	assert.Nil(t, err)
+
+	_, err = q.Enqueue("a")
+	assert.Nil(t, err)
+	assert.Nil(t, q.Shutdown(context.Background()))
+	assert.Equal(t, int32(3), attempts)
+
+	// the job is given up after MaxAttempts
+	var gaveUp []int
+	q, err = New(func(ctx context.Context, job Job) error {
+		return errors.New("unavailable")
+	}, Options{MaxAttempts: 2, Backoff: time.Millisecond, GiveUp: func(ctx context.Context, job Job, attempts int, err error) {
+		assert.EqualError(t, err, "unavailable")
+		gaveUp = append(gaveUp, attempts)
+	}})
+	assert.Nil(t, err)
+	_, err = q.Enqueue("a")
+	assert.Nil(t, err)
+	assert.Nil(t, q.Shutdown(context.Background()))
+	assert.Equal(t, []int{2}, gaveUp)
+}
+
+func TestQueueJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+
+	// the job is left in the journal when the shutdown gives up on it
+	started := make(chan struct{})
+	q, err := New(func(ctx context.Context, job Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}, Options{JournalPath: path})
+	assert.Nil(t, err)
+	id, err := q.Enqueue("a")
+	assert.Nil(t, err)
+	_, err = q.Enqueue("b")
+	assert.Nil(t, err)
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, q.Shutdown(ctx), context.DeadlineExceeded)
+
+	// even if the handler does not return the error of the cancellation
+	started = make(chan struct{})
+	q, err = New(func(ctx context.Context, job Job) error {
+		close(started)
+		<-ctx.Done()
+		return nil
+	}, Options{JournalPath: path})
+	assert.Nil(t, err)
+	<-started
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, q.Shutdown(ctx), context.DeadlineExceeded)
+
+	// and resumed after a restart
+	var mu sync.Mutex
+	var resumed []string
+	q, err = New(func(ctx context.Context, job Job) error {
+		var s string
+		_ = json.Unmarshal(job.Payload, &s)
+		mu.Lock()
+		resumed = append(resumed, s)
+		mu.Unlock()
+		if job.ID == id {
+			assert.Equal(t, "a", s)
+		}
+		return nil
+	}, Options{JournalPath: path})
+	assert.Nil(t, err)
+	assert.Nil(t, q.Shutdown(context.Background()))
+	assert.Equal(t, []string{"a", "b"}, resumed)
+
+	jobs, err := readJournal(path)
+	assert.Nil(t, err)
+	assert.Empty(t, jobs)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/ubie-oss/flow/v4/queue"
+)
+
+const (
+	defaultReadTimeout  = 30 * time.Second
+	defaultWriteTimeout = 5 * time.Minute
+	defaultIdleTimeout  = 2 * time.Minute
+	defaultMaxBodyBytes = 10 << 20
+
+	defaultShutdownTimeout      = 10 * time.Second
+	defaultQueueShutdownTimeout = 10 * time.Second
+)
+
+// ready is false until the server starts and after it starts shutting down.
+var ready atomic.Bool
+
+// newServer returns the server with the timeouts from FLOW_READ_TIMEOUT, FLOW_WRITE_TIMEOUT and FLOW_IDLE_TIMEOUT.
+// The write timeout is long by default as rollouts are processed in the handlers unless the queue is enabled.
+func newServer(addr string, handler http.Handler) *http.Server {
+	readTimeout := durationEnv("FLOW_READ_TIMEOUT", defaultReadTimeout)
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      durationEnv("FLOW_WRITE_TIMEOUT", defaultWriteTimeout),
+		IdleTimeout:       durationEnv("FLOW_IDLE_TIMEOUT", defaultIdleTimeout),
+	}
+}
+
+// shutdown stops accepting events, and waits for the rollouts in the handlers and then in the queue.
+// The server keeps serving for FLOW_SHUTDOWN_DELAY after /readyz starts failing so that load balancers stop
+// routing events to it first. The handlers and the queue get FLOW_SHUTDOWN_TIMEOUT and FLOW_QUEUE_SHUTDOWN_TIMEOUT
+// each, so that slow requests do not leave the queue without time to finish its rollouts.
+func shutdown(srv *http.Server, jobs *queue.Queue, shutdownTracing func(context.Context) error) {
+	ready.Store(false)
+	if delay := durationEnv("FLOW_SHUTDOWN_DELAY", 0); delay > 0 {
+		slog.Info("Draining", "delay", delay)
+		time.Sleep(delay)
+	}
+
+	timeout := durationEnv("FLOW_SHUTDOWN_TIMEOUT", defaultShutdownTimeout)
+	slog.Info("Shutting down", "timeout", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("Failed to shut down the server", "error", err)
+	}
+	if jobs != nil {
+		queueCtx, cancel := context.WithTimeout(context.Background(), durationEnv("FLOW_QUEUE_SHUTDOWN_TIMEOUT", defaultQueueShutdownTimeout))
+		defer cancel()
+		if err := jobs.Shutdown(queueCtx); err != nil {
+			slog.Error("Failed to drain the queue", "error", err, "left", jobs.Len())
+		}
+	}
+	// the traces of the rollouts are flushed once they are done
+	tracingCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := shutdownTracing(tracingCtx); err != nil {
+		slog.Error("Failed to flush the traces", "error", err)
+	}
+}
+
+// limitBody limits the size of request bodies to FLOW_MAX_BODY_BYTES.
+func limitBody(next http.Handler) http.Handler {
+	limit := int64(defaultMaxBodyBytes)
+	if n, err := strconv.ParseInt(os.Getenv("FLOW_MAX_BODY_BYTES"), 10, 64); err == nil && n > 0 {
+		limit = n
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readBody reads the request body, or responds with 413 or 400 and returns false.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		slog.Error("Failed to read request body", "error", err)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return nil, false
+	}
+	return body, true
+}
+
+// handleHealthz responds 200 as long as the process is serving.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// handleReadyz responds 503 before the server starts and while it is shutting down, so that no more events are routed to it.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !ready.Load() {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// durationEnv returns the duration in the environment variable, or def if it is not set or invalid.
+func durationEnv(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		slog.Warn("Ignoring invalid duration", "key", key, "value", v)
+		return def
+	}
+	return d
+}
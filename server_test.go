@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ubie-oss/flow/v4/queue"
+)
+
+func TestLimitBody(t *testing.T) {
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		if body, ok := readBody(w, r); ok {
+			_, _ = w.Write(body)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		limit string
+		size  int
+		want  int
+	}{
+		{"default", "", 1 << 20, http.StatusOK},
+		{"default exceeded", "", defaultMaxBodyBytes + 1, http.StatusRequestEntityTooLarge},
+		{"at the limit", "16", 16, http.StatusOK},
+		{"exceeded", "16", 17, http.StatusRequestEntityTooLarge},
+		{"invalid limit", "-1", 17, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("FLOW_MAX_BODY_BYTES", tt.limit)
+			body := strings.Repeat("x", tt.size)
+			w := httptest.NewRecorder()
+			limitBody(http.HandlerFunc(echo)).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+			assert.Equal(t, tt.want, w.Code)
+			if tt.want == http.StatusOK {
+				assert.Equal(t, body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	ready.Store(false)
+	w := httptest.NewRecorder()
+	handleHealthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestReadyz(t *testing.T) {
+	for _, tt := range []struct {
+		ready bool
+		want  int
+	}{
+		{false, http.StatusServiceUnavailable},
+		{true, http.StatusOK},
+	} {
+		ready.Store(tt.ready)
+		w := httptest.NewRecorder()
+		handleReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		assert.Equal(t, tt.want, w.Code, "ready: %v", tt.ready)
+	}
+	ready.Store(false)
+}
+
+func TestShutdown(t *testing.T) {
+	t.Setenv("FLOW_SHUTDOWN_DELAY", "200ms")
+	t.Setenv("FLOW_SHUTDOWN_TIMEOUT", "10ms")
+	t.Setenv("FLOW_QUEUE_SHUTDOWN_TIMEOUT", "1s")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/readyz", handleReadyz)
+	srv := newServer(l.Addr().String(), mux)
+	go func() { _ = srv.Serve(l) }()
+
+	// the job outlives the shutdown timeout of the server, but not the one of the queue
+	started := make(chan struct{})
+	var finished atomic.Bool
+	jobs, err := queue.New(func(ctx context.Context, job queue.Job) error {
+		close(started)
+		select {
+		case <-time.After(400 * time.Millisecond):
+			finished.Store(true)
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, queue.Options{JournalPath: filepath.Join(t.TempDir(), "journal"), MaxAttempts: 1})
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = jobs.Enqueue(map[string]string{})
+	assert.Nil(t, err)
+	<-started
+
+	ready.Store(true)
+	done := make(chan struct{})
+	var flushed bool
+	go func() {
+		shutdown(srv, jobs, func(ctx context.Context) error {
+			flushed = true
+			return ctx.Err()
+		})
+		close(done)
+	}()
+
+	// the server keeps serving during the delay, but is not ready
+	time.Sleep(50 * time.Millisecond)
+	res, err := http.Get("http://" + l.Addr().String() + "/readyz")
+	if assert.Nil(t, err) {
+		assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+		_ = res.Body.Close()
+	}
+
+	<-done
+	_, err = http.Get("http://" + l.Addr().String() + "/readyz")
+	assert.NotNil(t, err)
+	assert.True(t, finished.Load())
+	assert.True(t, flushed)
+}
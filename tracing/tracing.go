@@ -0,0 +1,192 @@
+// Package tracing sets up the OpenTelemetry traces of flow.
+// Spans are not recorded unless Init sets up an exporter.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName  = "github.com/ubie-oss/flow/v4"
+	serviceName = "flow"
+)
+
+// Attribute keys of the spans of rollouts.
+const (
+	MessageIDKey = attribute.Key("messaging.message.id")
+	ImageKey     = attribute.Key("flow.image")
+	VersionKey   = attribute.Key("flow.version")
+	EnvKey       = attribute.Key("flow.env")
+	RepoKey      = attribute.Key("flow.repo")
+	AttemptKey   = attribute.Key("flow.attempt")
+	JobIDKey     = attribute.Key("flow.job_id")
+)
+
+// Init sets up the exporter chosen by FLOW_TRACES_EXPORTER: otlp, configured by the standard
+// OTEL_EXPORTER_OTLP_* variables, stdout for local runs, or none by default.
+// The returned function flushes the spans and must be called on shutdown.
+func Init(ctx context.Context) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name := os.Getenv("FLOW_TRACES_EXPORTER"); name {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown traces exporter %q", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the traces exporter: %w", err)
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence over the default service name
+	res, err := resource.Merge(
+		resource.NewSchemaless(semconv.ServiceName(serviceName)),
+		resource.Environment(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the traces resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return tp.Shutdown, nil
+}
+
+// Start starts a span of flow with the attributes.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error, if any, and ends the span.
+// It is meant to be deferred with a named error result: defer func() { tracing.End(span, err) }().
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Middleware continues the trace of the W3C traceparent header of requests, so that the spans
+// of the handlers are children of the span of the caller, such as a proxy or a test client.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Carrier returns the trace context of ctx, to be kept with work done later such as a queued job.
+// It is empty if there is no span in ctx.
+func Carrier(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier
+}
+
+// StartLinked starts a span of flow in a new trace, linked to the span of the carrier returned by Carrier.
+// Work done later is not a child of the span which enqueued it, as that span ends long before.
+func StartLinked(ctx context.Context, name string, carrier map[string]string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	opts := []trace.SpanStartOption{trace.WithNewRoot(), trace.WithAttributes(attrs...)}
+	linked := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(carrier))
+	if sc := trace.SpanContextFromContext(linked); sc.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: sc}))
+	}
+	return otel.Tracer(tracerName).Start(ctx, name, opts...)
+}
+
+// transport records a client span for each request.
+type transport struct {
+	next http.RoundTripper
+}
+
+// Transport wraps next to record a span for each request to the GitHub API.
+func Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{next: next}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := otel.Tracer(tracerName).Start(req.Context(), req.Method+" "+route(req.URL.Path),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.ServerAddress(req.URL.Host),
+			semconv.URLPath(req.URL.Path),
+		),
+	)
+	defer span.End()
+
+	res, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return res, err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(res.StatusCode))
+	if res.StatusCode >= 400 {
+		span.SetStatus(codes.Error, res.Status)
+	}
+	return res, nil
+}
+
+// staticSegments are the segments of the GitHub API paths kept in span names.
+var staticSegments = map[string]bool{
+	"repos": true, "git": true, "trees": true, "commits": true, "blobs": true, "pulls": true,
+	"issues": true, "labels": true, "comments": true, "merge": true, "reviews": true, "check-suites": true, "check-runs": true, "app": true,
+	"installations": true, "access_tokens": true,
+}
+
+// pathSegments are followed by a parameter which may contain slashes, such as a file path or a branch.
+var pathSegments = map[string]bool{"contents": true, "ref": true, "refs": true, "compare": true}
+
+// route returns the path with its parameters replaced, e.g. /repos/{owner}/{repo}/git/trees,
+// so that span names do not contain branch names or file paths.
+func route(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	var parts []string
+	for i, s := range segments {
+		switch {
+		case i == 1 && segments[0] == "repos":
+			parts = append(parts, "{owner}")
+		case i == 2 && segments[0] == "repos":
+			parts = append(parts, "{repo}")
+		case pathSegments[s]:
+			parts = append(parts, s)
+			if i < len(segments)-1 {
+				parts = append(parts, "*")
+			}
+			return "/" + strings.Join(parts, "/")
+		case staticSegments[s]:
+			parts = append(parts, s)
+		default:
+			parts = append(parts, "*")
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
@@ -0,0 +1,104 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRoute(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/repos/ubie-oss/flow/git/trees", "/repos/{owner}/{repo}/git/trees"},
+		{"/repos/ubie-oss/flow/git/ref/heads/main", "/repos/{owner}/{repo}/git/ref/*"},
+		{"/repos/ubie-oss/flow/contents/apps/app/values.yaml", "/repos/{owner}/{repo}/contents/*"},
+		{"/repos/ubie-oss/flow/pulls/12/merge", "/repos/{owner}/{repo}/pulls/*/merge"},
+		{"/repos/ubie-oss/git/commits/abc", "/repos/{owner}/{repo}/commits/*"},
+		{"/app/installations/1/access_tokens", "/app/installations/*/access_tokens"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, route(tt.path))
+		})
+	}
+}
+
+func TestTransport(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(nil) })
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: Transport(nil)}
+	ctx, parent := Start(t.Context(), "parent")
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		req, _ := http.NewRequestWithContext(ctx, method, server.URL+"/repos/ubie-oss/flow/pulls", nil)
+		res, err := client.Do(req)
+		assert.Nil(t, err)
+		res.Body.Close()
+	}
+	parent.End()
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 3)
+	assert.Equal(t, "GET /repos/{owner}/{repo}/pulls", spans[0].Name())
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, "POST /repos/{owner}/{repo}/pulls", spans[1].Name())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+}
+
+func TestPropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(nil)
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	// the handler continues the trace of the caller and passes it to a job
+	var carrier map[string]string
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := Start(r.Context(), "handler")
+		defer span.End()
+		carrier = Carrier(ctx)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	_, job := StartLinked(t.Context(), "job", carrier)
+	job.End()
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", spans[0].SpanContext().TraceID().String())
+	assert.Equal(t, "b7ad6b7169203331", spans[0].Parent().SpanID().String())
+	assert.NotEqual(t, spans[0].SpanContext().TraceID(), spans[1].SpanContext().TraceID())
+	if assert.Len(t, spans[1].Links(), 1) {
+		assert.Equal(t, spans[0].SpanContext().SpanID(), spans[1].Links()[0].SpanContext.SpanID())
+	}
+
+	// jobs enqueued without a trace are not linked
+	_, job = StartLinked(t.Context(), "job", nil)
+	job.End()
+	assert.Empty(t, recorder.Ended()[2].Links())
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/go-github/v75/github"
+	"github.com/ubie-oss/flow/v4/flow"
+)
+
+// newGitHubWebhookHandler returns the handler of GitHub webhooks signed with FLOW_GITHUB_WEBHOOK_SECRET,
+// or nil if the secret is not set.
+func newGitHubWebhookHandler() http.HandlerFunc {
+	secret := os.Getenv("FLOW_GITHUB_WEBHOOK_SECRET")
+	if secret == "" {
+		return nil
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		handleGitHubWebhook(w, r, []byte(secret))
+	}
+}
+
+func handleGitHubWebhook(w http.ResponseWriter, r *http.Request, secret []byte) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	signature := r.Header.Get(github.SHA256SignatureHeader)
+	if signature == "" {
+		slog.Warn("Missing GitHub webhook signature", "delivery", github.DeliveryID(r))
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := github.ValidateSignature(signature, body, secret); err != nil {
+		slog.Warn("Invalid GitHub webhook signature", "delivery", github.DeliveryID(r), "error", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	event, err := github.ParseWebHook(github.WebHookType(r), body)
+	if err != nil {
+		slog.Error("Failed to parse GitHub webhook", "delivery", github.DeliveryID(r), "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	if flow.IsPullRequestEvent(event) {
+		flow.CountEvent("github", nil)
+		_, err := f.TrackGitHubEvent(r.Context(), event)
+		if err != nil {
+			slog.Error("Failed to track pull request", "delivery", github.DeliveryID(r), "error", err)
+		}
+		renderResponse(w, r, newResponse(nil, err))
+		return
+	}
+	opts := flow.Options{
+		DryRun:     r.URL.Query().Get("dry_run") == "true",
+		MessageID:  github.DeliveryID(r),
+		ReceivedAt: time.Now(),
+	}
+	rollouts, err := flow.GitHubEventRollouts(event, opts)
+	flow.CountEvent("github", rollouts)
+	if err != nil {
+		slog.Error("Failed to process GitHub event", "delivery", github.DeliveryID(r), "error", err)
+		renderResponse(w, r, newResponse(nil, err))
+		return
+	}
+	_ = dispatchRollouts(w, r, rollouts)
+}
+
+func handleRegistryNotification(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	envelope, err := flow.ParseRegistryEnvelope(body)
+	if err != nil {
+		slog.Error("Failed to parse registry notification", "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	opts := flow.Options{
+		DryRun:     r.URL.Query().Get("dry_run") == "true",
+		ReceivedAt: time.Now(),
+	}
+	rollouts := flow.RegistryEnvelopeRollouts(envelope, opts)
+	flow.CountEvent("registry", rollouts)
+	_ = dispatchRollouts(w, r, rollouts)
+}